@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const verdictFileName = "verdict.json"
+
+// sessionFrame is a recorded frame parsed for analysis.
+type sessionFrame struct {
+	Id        string
+	Time      time.Time
+	Diskstats map[string]diskStat
+	Power     map[string]string
+	PowerMode map[string]string
+	Log       string
+	Stdout    string
+	// Watts is the power drawn by each disk, when spd measures it
+	Watts map[string]float64
+}
+
+type verdict struct {
+	Verdict string `json:"verdict"`
+	Message string `json:"message"`
+}
+
+type diskAnalysis struct {
+	Disk              string  `json:"disk"`
+	SpinUps           int     `json:"spin_ups"`
+	SpinDowns         int     `json:"spin_downs"`
+	SpinDownLatencies []int64 `json:"spin_down_latencies"`
+	SpuriousSpinUps   int     `json:"spurious_spin_ups"`
+}
+
+// loadSessionFrames reads the frames of a session in chronological order.
+// The optional files missing in a frame are left empty.
+func loadSessionFrames(sessionDir string) ([]sessionFrame, error) {
+	frameDirs, err := os.ReadDir(sessionDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []sessionFrame
+	for _, e := range frameDirs {
+		if !e.IsDir() {
+			continue
+		}
+		unixTime, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		frame, err := loadSessionFrame(filepath.Join(sessionDir, e.Name()), time.Unix(unixTime, 0))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Time.Before(frames[j].Time)
+	})
+	return frames, nil
+}
+
+func loadSessionFrame(frameDir string, frameTime time.Time) (sessionFrame, error) {
+	diskstatsBytes, err := os.ReadFile(filepath.Join(frameDir, "diskstats"))
+	if err != nil {
+		return sessionFrame{}, err
+	}
+	powerBytes, _ := os.ReadFile(filepath.Join(frameDir, "power"))
+	powerModeBytes, _ := os.ReadFile(filepath.Join(frameDir, "powermode"))
+	logBytes, _ := os.ReadFile(filepath.Join(frameDir, "log"))
+	stdoutBytes, _ := os.ReadFile(filepath.Join(frameDir, "stdout"))
+	wattsBytes, _ := os.ReadFile(filepath.Join(frameDir, "watts"))
+	return sessionFrame{
+		Id:        filepath.Base(frameDir),
+		Time:      frameTime,
+		Diskstats: parseDiskstats(string(diskstatsBytes)),
+		Power:     parsePower(string(powerBytes)),
+		Watts:     parseWatts(string(wattsBytes)),
+		PowerMode: parsePower(string(powerModeBytes)),
+		Log:       string(logBytes),
+		Stdout:    string(stdoutBytes),
+	}, nil
+}
+
+// parsePower reads the "sda: up" lines written by collectPowerState, and the
+// "sda: standby" ones written by collectPowerMode.
+func parsePower(content string) map[string]string {
+	power := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		disk, state, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		power[strings.TrimSpace(disk)] = strings.TrimSpace(state)
+	}
+	return power
+}
+
+// parseWatts reads the "sda: 4.2" lines written by collectPowerState. The
+// power state comes from spd, a disk in standby still draws some power.
+func parseWatts(content string) map[string]float64 {
+	watts := make(map[string]float64)
+	for disk, value := range parsePower(content) {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			watts[disk] = number
+		}
+	}
+	return watts
+}
+
+// analyzeSession follows the power state of every disk. The spin-down latency
+// is the time between the last I/O on the disk and the frame where it is seen
+// down. A spin-up is spurious when the disk shows no I/O in the frame it
+// comes up nor in the next one, as the request waking a disk only completes
+// once it is spinning.
+func analyzeSession(frames []sessionFrame) []diskAnalysis {
+	var disks []string
+	seen := make(map[string]bool)
+	for _, frame := range frames {
+		for disk := range frame.Power {
+			if !seen[disk] {
+				seen[disk] = true
+				disks = append(disks, disk)
+			}
+		}
+	}
+	sort.Strings(disks)
+
+	var analyses []diskAnalysis
+	for _, disk := range disks {
+		analysis := diskAnalysis{Disk: disk, SpinDownLatencies: []int64{}}
+		var lastIO time.Time
+		if len(frames) > 0 {
+			lastIO = frames[0].Time
+		}
+		for i := 1; i < len(frames); i++ {
+			if diskActiveInFrame(frames, i, disk) {
+				lastIO = frames[i].Time
+			}
+
+			previous, current := frames[i-1].Power[disk], frames[i].Power[disk]
+			switch {
+			case previous == "down" && current == "up":
+				analysis.SpinUps++
+				if !diskActiveInFrame(frames, i, disk) && !diskActiveInFrame(frames, i+1, disk) {
+					analysis.SpuriousSpinUps++
+				}
+			case previous == "up" && current == "down":
+				analysis.SpinDowns++
+				analysis.SpinDownLatencies = append(analysis.SpinDownLatencies,
+					int64(frames[i].Time.Sub(lastIO)/time.Second))
+			}
+		}
+		analyses = append(analyses, analysis)
+	}
+	return analyses
+}
+
+func diskActiveInFrame(frames []sessionFrame, i int, disk string) bool {
+	if i <= 0 || i >= len(frames) {
+		return false
+	}
+	current, ok := frames[i].Diskstats[disk]
+	if !ok {
+		return false
+	}
+	return current.activeSince(frames[i-1].Diskstats[disk])
+}
+
+func saveVerdict(sessionDir string, v verdict) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionDir, verdictFileName), content, 0644)
+}
+
+func sessionVerdict(sessionDir string) (*verdict, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, verdictFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v verdict
+	err = json.Unmarshal(content, &v)
+	return &v, err
+}
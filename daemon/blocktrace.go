@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	blockRqIssueEvent      = "events/block/block_rq_issue"
+	blockTraceMarkFileName = "blocktrace.enabled"
+)
+
+// dd-1234 [001] d..1. 1234.567890: block_rq_issue: 8,0 WS 4096 () 123456 + 8 none,0,0 [dd]
+var blockRqIssueLine = regexp.MustCompile(
+	`^\s*.+-(\d+)\s+(?:\(.*?\)\s+)?\[\d+\]\s+(?:\S+\s+)?([\d.]+): block_rq_issue: (\d+),(\d+) (\S+) (\d+) \(.*?\) (\d+) \+ (\d+)(?: \S+)? \[(.*)\]$`)
+
+type blockRequest struct {
+	Timestamp string
+	Device    string
+	Flags     string
+	Bytes     string
+	Sector    string
+	Sectors   string
+	Pid       string
+	Comm      string
+}
+
+var (
+	tracefsDir = "/sys/kernel/tracing"
+
+	blockTraceMutex    sync.Mutex
+	blockRequests      []blockRequest
+	blockTraceStop     chan struct{}
+	blockTraceStopped  chan struct{}
+	blockTraceMarkFile string
+)
+
+// startBlockTracing enables the block_rq_issue tracepoint, filtered to the
+// given disks, and reads the issued requests from trace_pipe until
+// stopBlockTracing is called. A mark file is left in the data directory while
+// the tracepoint is enabled, so it can be disabled after a crash.
+func startBlockTracing(dataDir string, disks []string) error {
+	if len(disks) == 0 {
+		var err error
+		disks, err = physicalDisks()
+		if err != nil {
+			return err
+		}
+	}
+
+	var conditions []string
+	devices := make(map[string]string)
+	for _, disk := range disks {
+		majorMinor := readSysfsValue(filepath.Join(sysBlockDir, disk), "dev")
+		major, minor, found := strings.Cut(majorMinor, ":")
+		if !found {
+			return fmt.Errorf("unable to find device number of %s", disk)
+		}
+		majorNumber, _ := strconv.Atoi(major)
+		minorNumber, _ := strconv.Atoi(minor)
+		// the kernel's dev_t as seen by tracepoints is MKDEV(major, minor)
+		conditions = append(conditions, fmt.Sprintf("dev == %d", majorNumber<<20|minorNumber))
+		devices[major+","+minor] = disk
+	}
+
+	eventDir := filepath.Join(tracefsDir, blockRqIssueEvent)
+	err := os.WriteFile(filepath.Join(eventDir, "filter"), []byte(strings.Join(conditions, " || ")), 0644)
+	if err != nil {
+		return err
+	}
+	blockTraceMarkFile = filepath.Join(dataDir, blockTraceMarkFileName)
+	if err = os.WriteFile(blockTraceMarkFile, []byte(tracefsDir), 0644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(eventDir, "enable"), []byte("1"), 0644); err != nil {
+		disableBlockTracing(tracefsDir)
+		return err
+	}
+
+	fd, err := unix.Open(filepath.Join(tracefsDir, "trace_pipe"), unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		disableBlockTracing(tracefsDir)
+		return err
+	}
+
+	blockTraceMutex.Lock()
+	blockRequests = nil
+	blockTraceMutex.Unlock()
+	blockTraceStop = make(chan struct{})
+	blockTraceStopped = make(chan struct{})
+	go readTracePipe(fd, devices, blockTraceStop, blockTraceStopped)
+	log.Printf("Tracing block requests of %s", strings.Join(disks, ", "))
+	return nil
+}
+
+func stopBlockTracing() {
+	if blockTraceStop == nil {
+		return
+	}
+	close(blockTraceStop)
+	<-blockTraceStopped
+	blockTraceStop = nil
+	disableBlockTracing(tracefsDir)
+}
+
+// recoverBlockTracing disables the tracepoint left enabled by a previous run
+// of the daemon that didn't stop cleanly.
+func recoverBlockTracing(dataDir string) {
+	markFile := filepath.Join(dataDir, blockTraceMarkFileName)
+	tracefs, err := os.ReadFile(markFile)
+	if err != nil {
+		return
+	}
+	log.Println("Disabling block request tracing left enabled by a previous run")
+	blockTraceMarkFile = markFile
+	disableBlockTracing(string(tracefs))
+}
+
+func disableBlockTracing(tracefs string) {
+	eventDir := filepath.Join(tracefs, blockRqIssueEvent)
+	if err := os.WriteFile(filepath.Join(eventDir, "enable"), []byte("0"), 0644); err != nil {
+		log.Println(err)
+	}
+	if err := os.WriteFile(filepath.Join(eventDir, "filter"), []byte("0"), 0644); err != nil {
+		log.Println(err)
+	}
+	_ = os.Remove(blockTraceMarkFile)
+}
+
+func collectBlockTrace(frameDir string) error {
+	blockTraceMutex.Lock()
+	var content = ""
+	for _, r := range blockRequests {
+		content += fmt.Sprintf("%s %s %s %s + %s (%s B) %s %s\n",
+			r.Timestamp, r.Device, r.Flags, r.Sector, r.Sectors, r.Bytes, r.Pid, r.Comm)
+	}
+	blockRequests = nil
+	blockTraceMutex.Unlock()
+
+	return os.WriteFile(filepath.Join(frameDir, "blocktrace"), []byte(content), 0644)
+}
+
+// readTracePipe polls the non-blocking trace_pipe, so it can notice the stop
+// request even when no block request is being issued.
+func readTracePipe(fd int, devices map[string]string, stop, stopped chan struct{}) {
+	defer close(stopped)
+	defer unix.Close(fd)
+
+	var pending []byte
+	buffer := make([]byte, 64*1024)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		n, err := unix.Read(fd, buffer)
+		if err == unix.EINTR {
+			continue
+		}
+		if n <= 0 {
+			if err != nil && err != unix.EAGAIN {
+				log.Printf("Unable to read trace_pipe. %s", err)
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		pending = append(pending, buffer[:n]...)
+		for {
+			end := bytes.IndexByte(pending, '\n')
+			if end < 0 {
+				break
+			}
+			line := string(pending[:end])
+			pending = pending[end+1:]
+			if request, ok := parseBlockRqIssue(line, devices); ok {
+				blockTraceMutex.Lock()
+				blockRequests = append(blockRequests, request)
+				blockTraceMutex.Unlock()
+			}
+		}
+	}
+}
+
+func parseBlockRqIssue(line string, devices map[string]string) (blockRequest, bool) {
+	match := blockRqIssueLine.FindStringSubmatch(line)
+	if match == nil {
+		return blockRequest{}, false
+	}
+	device, ok := devices[match[3]+","+match[4]]
+	if !ok {
+		device = match[3] + "," + match[4]
+	}
+	return blockRequest{
+		Pid:       match[1],
+		Timestamp: match[2],
+		Device:    device,
+		Flags:     match[5],
+		Bytes:     match[6],
+		Sector:    match[7],
+		Sectors:   match[8],
+		Comm:      match[9],
+	}, true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeTracefs lays out the block_rq_issue event and a trace_pipe holding the
+// given lines in a temporary directory.
+func fakeTracefs(t *testing.T, pipe string) string {
+	t.Helper()
+	dir := t.TempDir()
+	eventDir := filepath.Join(dir, blockRqIssueEvent)
+	if err := os.MkdirAll(eventDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(eventDir, "enable"): "0",
+		filepath.Join(eventDir, "filter"): "none",
+		filepath.Join(dir, "trace_pipe"):  pipe,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readTracefs(t *testing.T, dir, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(dir, blockRqIssueEvent, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestBlockTracing(t *testing.T) {
+	tracefsDir = fakeTracefs(t,
+		"dd-1234 [001] d..1. 1234.567890: block_rq_issue: 8,0 WS 4096 () 123456 + 8 none,0,0 [dd]\n"+
+			"kworker/u8:2-99 [000] ..... 1235.000001: block_rq_issue: 8,16 R 512 () 2048 + 1 [kworker/u8:2]\n")
+	defer func() { tracefsDir = "/sys/kernel/tracing" }()
+	sysBlockDir = t.TempDir()
+	defer func() { sysBlockDir = "/sys/block" }()
+	for disk, dev := range map[string]string{"sda": "8:0", "sdb": "8:16"} {
+		if err := os.MkdirAll(filepath.Join(sysBlockDir, disk), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sysBlockDir, disk, "dev"), []byte(dev+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := t.TempDir()
+	if err := startBlockTracing(dataDir, []string{"sda", "sdb"}); err != nil {
+		t.Fatal(err)
+	}
+	if filter := readTracefs(t, tracefsDir, "filter"); filter != "dev == 8388608 || dev == 8388624" {
+		t.Errorf("filter '%s'", filter)
+	}
+	if enable := readTracefs(t, tracefsDir, "enable"); enable != "1" {
+		t.Errorf("enable '%s' while tracing", enable)
+	}
+	if mark, err := os.ReadFile(filepath.Join(dataDir, blockTraceMarkFileName)); err != nil || string(mark) != tracefsDir {
+		t.Errorf("mark file '%s' %v", mark, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		blockTraceMutex.Lock()
+		read := len(blockRequests)
+		blockTraceMutex.Unlock()
+		if read == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("read %d requests from trace_pipe, want 2", read)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	frameDir := t.TempDir()
+	if err := collectBlockTrace(frameDir); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(filepath.Join(frameDir, "blocktrace"))
+	want := "1234.567890 sda WS 123456 + 8 (4096 B) 1234 dd\n" +
+		"1235.000001 sdb R 2048 + 1 (512 B) 99 kworker/u8:2\n"
+	if string(content) != want {
+		t.Errorf("got\n%s\nwant\n%s", content, want)
+	}
+
+	stopBlockTracing()
+	if enable := readTracefs(t, tracefsDir, "enable"); enable != "0" {
+		t.Errorf("enable '%s' after stopping", enable)
+	}
+	if filter := readTracefs(t, tracefsDir, "filter"); filter != "0" {
+		t.Errorf("filter '%s' after stopping", filter)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, blockTraceMarkFileName)); !os.IsNotExist(err) {
+		t.Errorf("mark file left after stopping")
+	}
+}
+
+func TestRecoverBlockTracing(t *testing.T) {
+	// the tracefs of the run that crashed, not tracefsDir
+	leftover := fakeTracefs(t, "")
+	if err := os.WriteFile(filepath.Join(leftover, blockRqIssueEvent, "enable"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	markFile := filepath.Join(dataDir, blockTraceMarkFileName)
+	if err := os.WriteFile(markFile, []byte(leftover), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recoverBlockTracing(dataDir)
+	if enable := readTracefs(t, leftover, "enable"); enable != "0" {
+		t.Errorf("enable '%s' after recovering", enable)
+	}
+	if filter := readTracefs(t, leftover, "filter"); filter != "0" {
+		t.Errorf("filter '%s' after recovering", filter)
+	}
+	if _, err := os.Stat(markFile); !os.IsNotExist(err) {
+		t.Errorf("mark file left after recovering")
+	}
+
+	// nothing to recover without a mark file
+	recoverBlockTracing(t.TempDir())
+}
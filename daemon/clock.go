@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/madflojo/tasks"
+)
+
+const frameInterval = 5 * time.Second
+
+// virtualClock replaces the wall clock when the daemon runs against synthetic
+// sources. Time then only moves when advanced through POST /clock, and a
+// frame is collected for every frame interval crossed, so a scenario of
+// several minutes is recorded in a moment. With a simulator, every step is
+// forwarded to it first and its time is taken as the current one.
+type virtualClock struct {
+	advancing sync.Mutex
+	mutex     sync.Mutex
+	now       time.Time
+	simulator string
+	collect   func() error
+	nextFrame time.Time
+}
+
+var (
+	virtual   *virtualClock
+	scheduler = tasks.New()
+	// collectTask is the scheduler task collecting the frames, the scheduler
+	// runs the recording triggers as well
+	collectTask  string
+	collectMutex sync.Mutex
+	current      *collection
+)
+
+// collection runs the collect function of a recording. Once stopped it
+// doesn't start new frames, and wait returns when the frame in progress is
+// written.
+type collection struct {
+	mutex   sync.Mutex
+	stopped atomic.Bool
+	collect func() error
+}
+
+func (c *collection) run() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped.Load() {
+		return nil
+	}
+	return c.collect()
+}
+
+func (c *collection) wait() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+}
+
+func setupVirtualClock(simulatorSocket string) {
+	virtual = &virtualClock{now: time.Now().Truncate(time.Second), simulator: simulatorSocket}
+}
+
+// clockNow is the time frames and sessions are named after.
+func clockNow() time.Time {
+	if virtual == nil {
+		return time.Now()
+	}
+	virtual.mutex.Lock()
+	defer virtual.mutex.Unlock()
+	return virtual.now
+}
+
+// startCollecting runs collect every frame interval, on the wall clock or
+// on the virtual one, in place of the collection running, if any.
+func startCollecting(collect func() error) error {
+	collectMutex.Lock()
+	defer collectMutex.Unlock()
+	endCollection()
+	current = &collection{collect: collect}
+	if virtual == nil {
+		id, err := scheduler.Add(&tasks.Task{
+			Interval:          frameInterval,
+			RunSingleInstance: true,
+			TaskFunc:          current.run,
+		})
+		collectTask = id
+		return err
+	}
+	virtual.mutex.Lock()
+	defer virtual.mutex.Unlock()
+	virtual.collect = current.run
+	virtual.nextFrame = virtual.now.Add(frameInterval)
+	return nil
+}
+
+// syncClock takes the time of the simulator, which may have moved on its own.
+func syncClock() {
+	if virtual == nil || virtual.simulator == "" {
+		return
+	}
+	virtual.advancing.Lock()
+	defer virtual.advancing.Unlock()
+	if err := virtual.step(0); err != nil {
+		log.Println(err)
+	}
+}
+
+// stopCollecting stops the collection and returns it, to wait for the frame
+// it may still be writing.
+func stopCollecting() *collection {
+	collectMutex.Lock()
+	defer collectMutex.Unlock()
+	return endCollection()
+}
+
+// endCollection stops the collection running, if any, and returns it.
+// collectMutex must be held.
+func endCollection() *collection {
+	stopped := current
+	current = nil
+	if stopped != nil {
+		stopped.stopped.Store(true)
+	}
+	if virtual == nil {
+		if collectTask != "" {
+			scheduler.Del(collectTask)
+		}
+		collectTask = ""
+		return stopped
+	}
+	virtual.mutex.Lock()
+	defer virtual.mutex.Unlock()
+	virtual.collect = nil
+	return stopped
+}
+
+func isCollecting() bool {
+	if virtual == nil {
+		collectMutex.Lock()
+		defer collectMutex.Unlock()
+		return collectTask != ""
+	}
+	virtual.mutex.Lock()
+	defer virtual.mutex.Unlock()
+	return virtual.collect != nil
+}
+
+// advance moves the virtual clock forward in steps of at most one frame
+// interval, collecting the frames on the way.
+func (v *virtualClock) advance(d time.Duration) error {
+	v.advancing.Lock()
+	defer v.advancing.Unlock()
+
+	if v.simulator != "" {
+		if err := v.step(0); err != nil {
+			return err
+		}
+	}
+	target := clockNow().Add(d)
+	for {
+		v.mutex.Lock()
+		now, collect := v.now, v.collect
+		if collect != nil && v.nextFrame.Before(now) {
+			v.nextFrame = now.Add(frameInterval)
+		}
+		nextFrame := v.nextFrame
+		v.mutex.Unlock()
+		if !now.Before(target) {
+			return nil
+		}
+
+		step := min(target.Sub(now), frameInterval)
+		if collect != nil {
+			step = min(step, nextFrame.Sub(now))
+		}
+		if err := v.step(step); err != nil {
+			return err
+		}
+
+		if collect != nil && !clockNow().Before(nextFrame) {
+			// collect runs unlocked, it reads the clock itself
+			if err := collect(); err != nil {
+				log.Println(err)
+			}
+			v.mutex.Lock()
+			v.nextFrame = nextFrame.Add(frameInterval)
+			v.mutex.Unlock()
+		}
+	}
+}
+
+func (v *virtualClock) step(d time.Duration) error {
+	if v.simulator == "" {
+		v.mutex.Lock()
+		v.now = v.now.Add(d)
+		v.mutex.Unlock()
+		return nil
+	}
+	resp, err := socketClient(v.simulator).Post("http://unix/clock", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"advance":%d}`, int64(d/time.Second))))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	type Response struct {
+		Now   string `json:"now"`
+		Error string `json:"error"`
+	}
+	var response Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("simulator error: %s", response.Error)
+	}
+	now, err := time.Parse(time.RFC3339, response.Now)
+	if err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	v.now = now
+	v.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestStartCollectingReplacesCollection(t *testing.T) {
+	var first, second int
+	if err := startCollecting(func() error { first++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	previous := current
+	if err := startCollecting(func() error { second++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := len(scheduler.Tasks()); tasks != 1 {
+		t.Errorf("%d collect tasks scheduled, want 1", tasks)
+	}
+	if err := previous.run(); err != nil || first != 0 {
+		t.Errorf("the replaced collection still runs")
+	}
+	stopCollecting()
+
+	setupVirtualClock("")
+	defer func() {
+		stopCollecting()
+		virtual = nil
+	}()
+	if err := startCollecting(func() error { first++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := startCollecting(func() error { second++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := virtual.advance(2 * frameInterval); err != nil {
+		t.Fatal(err)
+	}
+	if first != 0 || second != 2 {
+		t.Errorf("collected %d frames with the replaced collection and %d with the new one, want 0 and 2", first, second)
+	}
+}
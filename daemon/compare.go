@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// compareTolerances are how much worse than the baseline a session may be
+// before it is reported as a regression. Latencies are in seconds.
+type compareTolerances struct {
+	SpinDownLatency float64 `json:"spin_down_latency"`
+	SpuriousSpinUps int     `json:"spurious_spin_ups"`
+	SpinUps         int     `json:"spin_ups"`
+	Anomalies       int     `json:"anomalies"`
+}
+
+var defaultCompareTolerances = compareTolerances{SpinDownLatency: 10}
+
+// alignedEvent is a session event with its time relative to the alignment
+// point of the session.
+type alignedEvent struct {
+	sessionEvent
+	Offset int64 `json:"offset"`
+}
+
+type comparedSession struct {
+	Session string `json:"session"`
+	// Origin is the time the events are aligned on, the first frame or the
+	// first marker with the given label
+	Origin    string           `json:"origin"`
+	Disks     []diskStatistics `json:"disks"`
+	Anomalies map[string]int   `json:"anomalies"`
+	Events    []alignedEvent   `json:"events"`
+}
+
+type regression struct {
+	Session   string  `json:"session"`
+	Disk      string  `json:"disk"`
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Value     float64 `json:"value"`
+	Tolerance float64 `json:"tolerance"`
+	Message   string  `json:"message"`
+}
+
+// loadComparedSession computes the statistics and the events of a session,
+// aligned on its first frame or, when marker is given, on the first marker
+// with that label.
+func loadComparedSession(dataDir, id, marker string) (comparedSession, error) {
+	sessionDir := filepath.Join(dataDir, id)
+	compared := comparedSession{Session: id, Anomalies: make(map[string]int)}
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		return compared, err
+	}
+	if len(frames) == 0 {
+		return compared, fmt.Errorf("no frames in session %s", compared.Session)
+	}
+	mapping, err := sessionDiskMapping(sessionDir)
+	if err != nil {
+		mapping = legacyDiskMapping(dataDir)
+	}
+	idleTimes, err := sessionIdleTimes(sessionDir, mapping)
+	if err != nil {
+		return compared, err
+	}
+
+	origin := frames[0].Time
+	if marker != "" {
+		markers, err := sessionMarkers(sessionDir)
+		if err != nil {
+			return compared, err
+		}
+		found := false
+		for _, m := range markers {
+			if m.Label != marker {
+				continue
+			}
+			if origin, err = time.Parse(time.RFC3339, m.Frame); err != nil {
+				return compared, err
+			}
+			found = true
+			break
+		}
+		if !found {
+			return compared, fmt.Errorf("no marker '%s' in session %s", marker, compared.Session)
+		}
+	}
+	compared.Origin = origin.Format(time.RFC3339)
+	compared.Disks = sessionStatistics(frames, idleTimes)
+
+	for _, event := range verifySession(frames, mapping, verifyWindow) {
+		eventTime, err := time.Parse(time.RFC3339, event.Time)
+		if err != nil {
+			return compared, err
+		}
+		if event.Type == "anomaly" {
+			compared.Anomalies[event.Disk]++
+		}
+		compared.Events = append(compared.Events, alignedEvent{
+			sessionEvent: event,
+			Offset:       int64(eventTime.Sub(origin) / time.Second),
+		})
+	}
+	return compared, nil
+}
+
+// compareSessions checks every session against the first one, the baseline,
+// disk by disk: the mean spin-down latency, the spin-ups, the spurious ones
+// and the anomalies must not grow beyond the tolerances, and every spin-down
+// of the baseline must happen at most the latency tolerance later.
+func compareSessions(sessions []comparedSession, tolerances compareTolerances) []regression {
+	regressions := []regression{}
+	if len(sessions) == 0 {
+		return regressions
+	}
+	baseline := sessions[0]
+	for _, session := range sessions[1:] {
+		for _, base := range baseline.Disks {
+			disk := base.Disk
+			var current *diskStatistics
+			for i := range session.Disks {
+				if session.Disks[i].Disk == disk {
+					current = &session.Disks[i]
+				}
+			}
+			if current == nil {
+				regressions = append(regressions, regression{Session: session.Session, Disk: disk, Metric: "disk",
+					Message: "disk missing"})
+				continue
+			}
+
+			check := func(metric, name string, baseValue, value, tolerance float64, unit string) {
+				if value-baseValue > tolerance {
+					regressions = append(regressions, regression{
+						Session:   session.Session,
+						Disk:      disk,
+						Metric:    metric,
+						Baseline:  baseValue,
+						Value:     value,
+						Tolerance: tolerance,
+						Message:   fmt.Sprintf("%s went from %g%s to %g%s", name, baseValue, unit, value, unit),
+					})
+				}
+			}
+			if len(base.SpinDownLatencies) > 0 && len(current.SpinDownLatencies) > 0 {
+				check("spin_down_latency", "mean spin-down latency",
+					base.MeanSpinDownLatency, current.MeanSpinDownLatency, tolerances.SpinDownLatency, "s")
+			}
+			if current.SpinDowns < base.SpinDowns {
+				regressions = append(regressions, regression{
+					Session:  session.Session,
+					Disk:     disk,
+					Metric:   "spin_downs",
+					Baseline: float64(base.SpinDowns),
+					Value:    float64(current.SpinDowns),
+					Message:  fmt.Sprintf("spin-downs went from %d to %d", base.SpinDowns, current.SpinDowns),
+				})
+			}
+			check("spin_ups", "spin-ups", float64(base.SpinUps), float64(current.SpinUps), float64(tolerances.SpinUps), "")
+			check("spurious_spin_ups", "spurious spin-ups",
+				float64(base.SpuriousSpinUps), float64(current.SpuriousSpinUps), float64(tolerances.SpuriousSpinUps), "")
+			check("anomalies", "anomalies",
+				float64(baseline.Anomalies[disk]), float64(session.Anomalies[disk]), float64(tolerances.Anomalies), "")
+
+			baseDowns := alignedSpinDowns(baseline.Events, disk)
+			downs := alignedSpinDowns(session.Events, disk)
+			for i := 0; i < len(baseDowns) && i < len(downs); i++ {
+				if float64(downs[i]-baseDowns[i]) > tolerances.SpinDownLatency {
+					regressions = append(regressions, regression{
+						Session:   session.Session,
+						Disk:      disk,
+						Metric:    "spin_down_offset",
+						Baseline:  float64(baseDowns[i]),
+						Value:     float64(downs[i]),
+						Tolerance: tolerances.SpinDownLatency,
+						Message:   fmt.Sprintf("spin-down %d at %+ds instead of %+ds", i+1, downs[i], baseDowns[i]),
+					})
+				}
+			}
+		}
+	}
+	return regressions
+}
+
+// alignedSpinDowns returns the offsets of the disk going down, as seen by spd
+// or, when there is no power data, as reported by the drive.
+func alignedSpinDowns(events []alignedEvent, disk string) []int64 {
+	var spd, drive []int64
+	for _, event := range events {
+		if event.Disk != disk {
+			continue
+		}
+		switch event.Type {
+		case "power_down":
+			spd = append(spd, event.Offset)
+		case "drive_down":
+			drive = append(drive, event.Offset)
+		}
+	}
+	if len(spd) > 0 {
+		return spd
+	}
+	return drive
+}
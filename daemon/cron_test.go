@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Thursday
+	from := time.Date(2026, time.January, 1, 10, 30, 0, 0, time.UTC)
+	for _, test := range []struct {
+		expression string
+		want       []string
+	}{
+		{"30 2 * * *", []string{"2026-01-02 02:30", "2026-01-03 02:30"}},
+		{"*/20 * * * *", []string{"2026-01-01 10:40", "2026-01-01 11:00", "2026-01-01 11:20"}},
+		{"10-30/10 12 * * *", []string{"2026-01-01 12:10", "2026-01-01 12:20", "2026-01-01 12:30", "2026-01-02 12:10"}},
+		{"5/30 * * * *", []string{"2026-01-01 10:35", "2026-01-01 11:05"}},
+		{"0 9 * * 1-5", []string{"2026-01-02 09:00", "2026-01-05 09:00"}},
+		{"0 0 * * 7", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		{"0 0 * * 0", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		// either the 15th or a Monday
+		{"0 0 15 * 1", []string{"2026-01-05 00:00", "2026-01-12 00:00", "2026-01-15 00:00", "2026-01-19 00:00"}},
+		{"0 0 15 * *", []string{"2026-01-15 00:00", "2026-02-15 00:00"}},
+		{"0 0 */10 * *", []string{"2026-01-11 00:00", "2026-01-21 00:00", "2026-01-31 00:00", "2026-02-01 00:00"}},
+		// a step on the day of month isn't a restriction, both must match
+		{"0 0 */10 * 1", []string{"2026-05-11 00:00"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00"}},
+		{"@hourly", []string{"2026-01-01 11:00", "2026-01-01 12:00"}},
+		{"@daily", []string{"2026-01-02 00:00"}},
+		{"@weekly", []string{"2026-01-04 00:00", "2026-01-11 00:00"}},
+		{"@monthly", []string{"2026-02-01 00:00", "2026-03-01 00:00"}},
+		{"@yearly", []string{"2027-01-01 00:00"}},
+		{"0 0 30 2 *", []string{""}},
+	} {
+		t.Run(test.expression, func(t *testing.T) {
+			schedule, err := parseCron(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := from
+			for _, want := range test.want {
+				next = schedule.next(next)
+				got := ""
+				if !next.IsZero() {
+					got = next.Format("2006-01-02 15:04")
+				}
+				if got != want {
+					t.Fatalf("got '%s', want '%s'", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often",
+	} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("'%s' parsed", expression)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	devDiskDir                = "/dev/disk"
+	diskMappingSessionFile    = "disk_mapping.json"
+	legacyDiskMappingFileName = "disk_mapping.txt"
+)
+
+var (
+	diskMappingMutex   sync.Mutex
+	diskMapping        = make(map[string]string)
+	diskMappingSession string
+)
+
+// resolveDiskMapping maps every persistent name udev creates under /dev/disk
+// (by-id, including the wwn and serial based names, by-uuid, by-path,
+// by-label...) to the kernel name of the device, following all the links.
+func resolveDiskMapping() (map[string]string, error) {
+	mapping := make(map[string]string)
+	kinds, err := os.ReadDir(devDiskDir)
+	if err != nil {
+		return mapping, err
+	}
+
+	for _, kind := range kinds {
+		links, err := os.ReadDir(filepath.Join(devDiskDir, kind.Name()))
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			linkPath := filepath.Join(devDiskDir, kind.Name(), link.Name())
+			devicePath, err := filepath.EvalSymlinks(linkPath)
+			if err != nil {
+				continue
+			}
+			mapping[linkPath] = filepath.Base(devicePath)
+		}
+	}
+	return mapping, nil
+}
+
+func refreshDiskMapping() {
+	mapping, err := resolveDiskMapping()
+	if err != nil {
+		log.Printf("Unable to resolve disk mapping. %s", err)
+	}
+
+	diskMappingMutex.Lock()
+	defer diskMappingMutex.Unlock()
+	diskMapping = mapping
+	if diskMappingSession != "" {
+		if err = updateSessionDiskMapping(diskMappingSession, mapping); err != nil {
+			log.Printf("Unable to save session disk mapping. %s", err)
+		}
+	}
+}
+
+func currentDiskMapping() map[string]string {
+	diskMappingMutex.Lock()
+	defer diskMappingMutex.Unlock()
+	mapping := make(map[string]string, len(diskMapping))
+	for name, device := range diskMapping {
+		mapping[name] = device
+	}
+	return mapping
+}
+
+// snapshotDiskMapping stores the current mapping in the session and keeps it
+// updated on udev changes until the recording stops.
+func snapshotDiskMapping(sessionDir string) error {
+	diskMappingMutex.Lock()
+	defer diskMappingMutex.Unlock()
+	diskMappingSession = sessionDir
+	return updateSessionDiskMapping(sessionDir, diskMapping)
+}
+
+func stopDiskMappingSnapshot() {
+	diskMappingMutex.Lock()
+	defer diskMappingMutex.Unlock()
+	diskMappingSession = ""
+}
+
+// updateSessionDiskMapping merges the mapping into the one of the session, so
+// names of devices unplugged during the recording are kept.
+func updateSessionDiskMapping(sessionDir string, mapping map[string]string) error {
+	merged, err := sessionDiskMapping(sessionDir)
+	if err != nil {
+		merged = make(map[string]string)
+	}
+	for name, device := range mapping {
+		merged[name] = device
+	}
+
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(sessionDir, 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionDir, diskMappingSessionFile), content, 0644)
+}
+
+func sessionDiskMapping(sessionDir string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, diskMappingSessionFile))
+	if err != nil {
+		return nil, err
+	}
+	mapping := make(map[string]string)
+	err = json.Unmarshal(content, &mapping)
+	return mapping, err
+}
+
+// legacyDiskMapping reads the "path:device" lines written by older versions of
+// the daemon. Paths like by-path names contain colons, so the device is what
+// follows the last one.
+func legacyDiskMapping(dataDir string) map[string]string {
+	mapping := make(map[string]string)
+	content, err := os.ReadFile(filepath.Join(dataDir, legacyDiskMappingFileName))
+	if err != nil {
+		return mapping
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		separator := strings.LastIndex(line, ":")
+		if separator < 0 {
+			continue
+		}
+		mapping[line[:separator]] = line[separator+1:]
+	}
+	return mapping
+}
+
+// watchUdevChanges refreshes the disk mapping when block devices are added,
+// removed or changed. udev creates the /dev/disk links after the kernel
+// event, so the refresh is delayed a little.
+func watchUdevChanges() {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		log.Printf("Unable to watch udev changes. %s", err)
+		return
+	}
+	defer unix.Close(fd)
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		log.Printf("Unable to watch udev changes. %s", err)
+		return
+	}
+
+	var refresh *time.Timer
+	buffer := make([]byte, 8192)
+	for {
+		n, _, err := unix.Recvfrom(fd, buffer, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Printf("Stopped watching udev changes. %s", err)
+			return
+		}
+		if !isBlockUevent(buffer[:n]) {
+			continue
+		}
+		if refresh != nil {
+			refresh.Stop()
+		}
+		refresh = time.AfterFunc(2*time.Second, refreshDiskMapping)
+	}
+}
+
+func isBlockUevent(message []byte) bool {
+	for _, field := range bytes.Split(message, []byte{0}) {
+		if string(field) == "SUBSYSTEM=block" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+type diskStat struct {
+	Device          string
+	ReadsCompleted  uint64
+	SectorsRead     uint64
+	WritesCompleted uint64
+	SectorsWritten  uint64
+}
+
+func (d diskStat) activeSince(previous diskStat) bool {
+	return d.SectorsRead != previous.SectorsRead || d.SectorsWritten != previous.SectorsWritten
+}
+
+// parseDiskstats turns the content of /proc/diskstats into a map keyed by
+// device name. Lines that don't carry the read and write counters are ignored.
+func parseDiskstats(content string) map[string]diskStat {
+	stats := make(map[string]diskStat)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) < 10 {
+			continue
+		}
+		stats[cols[2]] = diskStat{
+			Device:          cols[2],
+			ReadsCompleted:  parseCounter(cols[3]),
+			SectorsRead:     parseCounter(cols[5]),
+			WritesCompleted: parseCounter(cols[7]),
+			SectorsWritten:  parseCounter(cols[9]),
+		}
+	}
+	return stats
+}
+
+func parseCounter(value string) uint64 {
+	counter, _ := strconv.ParseUint(value, 10, 64)
+	return counter
+}
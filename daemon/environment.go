@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hdidleDefaultsFile        = "/etc/default/hd-idle"
+	environmentStartFileName  = "environment_start.json"
+	environmentEndFileName    = "environment_end.json"
+	smartctlOpenFailedExitBit = 2
+)
+
+// diskServices are the processes known to access disks on their own and
+// therefore to be able to wake them up.
+var diskServices = []string{"smartd", "udisksd", "smbd", "nfsd", "tracker-miner-f", "baloo_file"}
+
+type environment struct {
+	CapturedAt     string         `json:"captured_at"`
+	Kernel         string         `json:"kernel"`
+	HdIdle         []hdIdleBinary `json:"hd_idle"`
+	HdIdleDefaults string         `json:"hd_idle_defaults"`
+	Topology       []diskTopology `json:"topology"`
+	Mounts         []mount        `json:"mounts"`
+	Services       []service      `json:"services"`
+	Smart          []smartCounter `json:"smart"`
+}
+
+type hdIdleBinary struct {
+	Pid     int      `json:"pid"`
+	Binary  string   `json:"binary"`
+	Sha256  string   `json:"sha256"`
+	Version string   `json:"version"`
+	Cmdline []string `json:"cmdline"`
+}
+
+type service struct {
+	Name string `json:"name"`
+	Pids []int  `json:"pids"`
+}
+
+type smartCounter struct {
+	Disk            string `json:"disk"`
+	StartStopCount  string `json:"start_stop_count"`
+	LoadCycleCount  string `json:"load_cycle_count"`
+	PowerCycleCount string `json:"power_cycle_count"`
+	Error           string `json:"error,omitempty"`
+}
+
+func saveEnvironment(sessionDir, fileName string) error {
+	content, err := json.Marshal(captureEnvironment())
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(sessionDir, 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionDir, fileName), content, 0644)
+}
+
+func sessionEnvironment(sessionDir, fileName string) (*environment, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, fileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env environment
+	err = json.Unmarshal(content, &env)
+	return &env, err
+}
+
+// captureEnvironment collects what is needed to reproduce a session. Every
+// part is best effort, a missing tool or file leaves its part empty.
+func captureEnvironment() environment {
+	env := environment{CapturedAt: time.Now().Format(time.RFC3339)}
+
+	if kernel, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		env.Kernel = strings.TrimSpace(string(kernel))
+	}
+	if defaults, err := os.ReadFile(hdidleDefaultsFile); err == nil {
+		env.HdIdleDefaults = string(defaults)
+	}
+	env.Topology, _ = buildTopology()
+	if mounts, err := readMounts(); err == nil {
+		for _, m := range mounts {
+			if strings.HasPrefix(m.Source, "/dev/") {
+				env.Mounts = append(env.Mounts, m)
+			}
+		}
+	}
+
+	processes := processesByComm()
+	for _, pid := range processes["hd-idle"] {
+		env.HdIdle = append(env.HdIdle, describeHdIdle(pid))
+	}
+	for _, name := range diskServices {
+		if pids, ok := processes[name]; ok {
+			env.Services = append(env.Services, service{Name: name, Pids: pids})
+		}
+	}
+
+	for _, t := range env.Topology {
+		env.Smart = append(env.Smart, readSmartCounters(t.Disk))
+	}
+	return env
+}
+
+func processesByComm() map[string][]int {
+	processes := make(map[string][]int)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return processes
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		comm := processComm(pid)
+		processes[comm] = append(processes[comm], pid)
+	}
+	return processes
+}
+
+func describeHdIdle(pid int) hdIdleBinary {
+	binary := hdIdleBinary{Pid: pid}
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		binary.Cmdline = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		binary.Binary = exe
+	}
+	binary.Sha256 = fileSha256(fmt.Sprintf("/proc/%d/exe", pid))
+	binary.Version = packageVersion(binary.Binary)
+	return binary
+}
+
+func fileSha256(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// packageVersion asks dpkg for the version of the package owning the binary.
+// hd-idle builds installed by hand are reported as "unpackaged".
+func packageVersion(binary string) string {
+	if binary == "" {
+		return ""
+	}
+	owner, err := exec.Command("dpkg-query", "-S", binary).Output()
+	if err != nil {
+		return "unpackaged"
+	}
+	packageName, _, _ := strings.Cut(string(owner), ":")
+	version, err := exec.Command("dpkg-query", "-W", "-f=${Version}", packageName).Output()
+	if err != nil {
+		return "unpackaged"
+	}
+	return fmt.Sprintf("%s %s", packageName, version)
+}
+
+// readSmartCounters runs smartctl with "-n standby", so a disk that is spun
+// down is not woken up only to read its counters.
+func readSmartCounters(disk string) smartCounter {
+	counter := smartCounter{Disk: disk}
+	output, err := exec.Command("smartctl", "-n", "standby", "-A", "/dev/"+disk).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode()&smartctlOpenFailedExitBit != 0 {
+		counter.Error = "skipped, disk in standby or not accessible"
+		return counter
+	}
+	if err != nil && len(output) == 0 {
+		counter.Error = err.Error()
+		return counter
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		cols := strings.Fields(line)
+		if len(cols) < 10 {
+			continue
+		}
+		switch cols[1] {
+		case "Start_Stop_Count":
+			counter.StartStopCount = cols[9]
+		case "Load_Cycle_Count":
+			counter.LoadCycleCount = cols[9]
+		case "Power_Cycle_Count":
+			counter.PowerCycleCount = cols[9]
+		}
+	}
+	return counter
+}
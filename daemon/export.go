@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormats are the formats of GET /sessions/:id/export, with their
+// content type.
+var exportFormats = map[string]string{
+	"csv":    "text/csv",
+	"jsonl":  "application/x-ndjson",
+	"influx": "text/plain",
+}
+
+// exportRow is a device in a frame, flattened for time-series tools. The
+// deltas are against the previous frame, 0 in the first one.
+type exportRow struct {
+	Time                 time.Time `json:"time"`
+	Frame                string    `json:"frame"`
+	Device               string    `json:"device"`
+	ReadsCompleted       uint64    `json:"reads_completed"`
+	SectorsRead          uint64    `json:"sectors_read"`
+	WritesCompleted      uint64    `json:"writes_completed"`
+	SectorsWritten       uint64    `json:"sectors_written"`
+	ReadsCompletedDelta  uint64    `json:"reads_completed_delta"`
+	SectorsReadDelta     uint64    `json:"sectors_read_delta"`
+	WritesCompletedDelta uint64    `json:"writes_completed_delta"`
+	SectorsWrittenDelta  uint64    `json:"sectors_written_delta"`
+	Active               bool      `json:"active"`
+	Power                string    `json:"power"`
+	Watts                *float64  `json:"watts"`
+	DriveMode            string    `json:"drive_mode"`
+	SpinDownClaim        bool      `json:"spindown_claim"`
+	SpinUpClaim          bool      `json:"spinup_claim"`
+	Anomaly              bool      `json:"anomaly"`
+}
+
+// sessionExportRows flattens the frames into one row per frame and device.
+// Without devices, the physical disks of the topology and the ones with a
+// power state are exported.
+func sessionExportRows(frames []sessionFrame, topology []diskTopology, mapping map[string]string, devices []string) []exportRow {
+	if len(devices) == 0 {
+		seen := make(map[string]bool)
+		for _, t := range topology {
+			seen[t.Disk] = true
+		}
+		for _, frame := range frames {
+			for disk := range frame.Power {
+				seen[disk] = true
+			}
+		}
+		devices = sortedKeys(seen)
+	}
+
+	claims := make(map[string]bool)
+	for _, claim := range hdIdleClaims(frames, mapping) {
+		claims[claim.Frame+"/"+claim.Disk+"/"+claim.Kind] = true
+	}
+	anomalies := make(map[string]bool)
+	for _, event := range verifySession(frames, mapping, verifyWindow) {
+		if event.Type == "anomaly" {
+			anomalies[event.Frame+"/"+event.Disk] = true
+		}
+	}
+
+	rows := []exportRow{}
+	for i, frame := range frames {
+		for _, device := range devices {
+			stat, ok := frame.Diskstats[device]
+			if !ok {
+				continue
+			}
+			row := exportRow{
+				Time:            frame.Time,
+				Frame:           frame.Id,
+				Device:          device,
+				ReadsCompleted:  stat.ReadsCompleted,
+				SectorsRead:     stat.SectorsRead,
+				WritesCompleted: stat.WritesCompleted,
+				SectorsWritten:  stat.SectorsWritten,
+				Active:          diskActiveInFrame(frames, i, device),
+				Power:           frame.Power[device],
+				DriveMode:       frame.PowerMode[device],
+				SpinDownClaim:   claims[frame.Id+"/"+device+"/down"],
+				SpinUpClaim:     claims[frame.Id+"/"+device+"/up"],
+				Anomaly:         anomalies[frame.Id+"/"+device],
+			}
+			if watts, ok := frame.Watts[device]; ok {
+				row.Watts = &watts
+			}
+			if i > 0 {
+				if previous, ok := frames[i-1].Diskstats[device]; ok {
+					row.ReadsCompletedDelta = counterDelta(stat.ReadsCompleted, previous.ReadsCompleted)
+					row.SectorsReadDelta = counterDelta(stat.SectorsRead, previous.SectorsRead)
+					row.WritesCompletedDelta = counterDelta(stat.WritesCompleted, previous.WritesCompleted)
+					row.SectorsWrittenDelta = counterDelta(stat.SectorsWritten, previous.SectorsWritten)
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// counterDelta is 0 when the counter went backwards, e.g. the disk was
+// plugged in again.
+func counterDelta(current, previous uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
+
+func writeExport(w io.Writer, format, session string, rows []exportRow) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, rows)
+	case "jsonl":
+		return writeJSONLines(w, rows)
+	case "influx":
+		return writeInflux(w, session, rows)
+	}
+	return fmt.Errorf("unknown export format '%s'", format)
+}
+
+func writeCSV(w io.Writer, rows []exportRow) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"time", "frame", "device",
+		"reads_completed", "sectors_read", "writes_completed", "sectors_written",
+		"reads_completed_delta", "sectors_read_delta", "writes_completed_delta", "sectors_written_delta",
+		"active", "power", "watts", "drive_mode", "spindown_claim", "spinup_claim", "anomaly"})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		watts := ""
+		if row.Watts != nil {
+			watts = strconv.FormatFloat(*row.Watts, 'f', -1, 64)
+		}
+		err = writer.Write([]string{row.Time.Format(time.RFC3339), row.Frame, row.Device,
+			strconv.FormatUint(row.ReadsCompleted, 10), strconv.FormatUint(row.SectorsRead, 10),
+			strconv.FormatUint(row.WritesCompleted, 10), strconv.FormatUint(row.SectorsWritten, 10),
+			strconv.FormatUint(row.ReadsCompletedDelta, 10), strconv.FormatUint(row.SectorsReadDelta, 10),
+			strconv.FormatUint(row.WritesCompletedDelta, 10), strconv.FormatUint(row.SectorsWrittenDelta, 10),
+			strconv.FormatBool(row.Active), row.Power, watts, row.DriveMode,
+			strconv.FormatBool(row.SpinDownClaim), strconv.FormatBool(row.SpinUpClaim), strconv.FormatBool(row.Anomaly)})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONLines(w io.Writer, rows []exportRow) error {
+	encoder := json.NewEncoder(w)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeInflux writes the rows in the InfluxDB line protocol, in the hdtd
+// measurement tagged with the session and the device, with timestamps in
+// seconds (precision=s).
+func writeInflux(w io.Writer, session string, rows []exportRow) error {
+	for _, row := range rows {
+		fields := []string{
+			fmt.Sprintf("reads_completed=%di", row.ReadsCompleted),
+			fmt.Sprintf("sectors_read=%di", row.SectorsRead),
+			fmt.Sprintf("writes_completed=%di", row.WritesCompleted),
+			fmt.Sprintf("sectors_written=%di", row.SectorsWritten),
+			fmt.Sprintf("reads_completed_delta=%di", row.ReadsCompletedDelta),
+			fmt.Sprintf("sectors_read_delta=%di", row.SectorsReadDelta),
+			fmt.Sprintf("writes_completed_delta=%di", row.WritesCompletedDelta),
+			fmt.Sprintf("sectors_written_delta=%di", row.SectorsWrittenDelta),
+			fmt.Sprintf("active=%t", row.Active),
+			fmt.Sprintf("spindown_claim=%t", row.SpinDownClaim),
+			fmt.Sprintf("spinup_claim=%t", row.SpinUpClaim),
+			fmt.Sprintf("anomaly=%t", row.Anomaly),
+		}
+		if row.Power != "" {
+			fields = append(fields, fmt.Sprintf("power=%s", influxString(row.Power)))
+		}
+		if row.Watts != nil {
+			fields = append(fields, fmt.Sprintf("watts=%g", *row.Watts))
+		}
+		if row.DriveMode != "" {
+			fields = append(fields, fmt.Sprintf("drive_mode=%s", influxString(row.DriveMode)))
+		}
+		sort.Strings(fields)
+		_, err := fmt.Fprintf(w, "hdtd,session=%s,device=%s %s %d\n",
+			influxTag(session), influxTag(row.Device), strings.Join(fields, ","), row.Time.Unix())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func influxTag(value string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
+
+func influxString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
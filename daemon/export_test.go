@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// recordFrames builds frames whose stdout is collected from a growing hd-idle
+// output, with sda in the given power states.
+func recordFrames(t *testing.T, growth, power []string) []sessionFrame {
+	t.Helper()
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "hd-idle.out")
+	if err := os.WriteFile(origin, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	logLen := logNotRead
+	start := time.Unix(1000, 0)
+	var frames []sessionFrame
+	for i, lines := range growth {
+		if err := appendFile(origin, lines); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(dir, "stdout")
+		if err := collectLog(origin, dest, &logLen); err != nil {
+			t.Fatal(err)
+		}
+		stdout, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frameTime := start.Add(time.Duration(i) * frameInterval)
+		frames = append(frames, sessionFrame{
+			Id:        strconv.FormatInt(frameTime.Unix(), 10),
+			Time:      frameTime,
+			Diskstats: map[string]diskStat{"sda": {Device: "sda"}},
+			Power:     map[string]string{"sda": power[i]},
+			Stdout:    string(stdout),
+		})
+	}
+	return frames
+}
+
+func TestExportFlagsEachClaimOnce(t *testing.T) {
+	frames := recordFrames(t,
+		[]string{"", "sda spindown\n", "", "", "", "", "", "", "", "sda spinup\n", ""},
+		[]string{"up", "up", "up", "up", "up", "up", "up", "up", "up", "up", "up"})
+
+	rows := sessionExportRows(frames, nil, nil, []string{"sda"})
+	if len(rows) != len(frames) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(frames))
+	}
+	spinDowns, spinUps, anomalies := 0, 0, 0
+	for _, row := range rows {
+		if row.SpinDownClaim {
+			spinDowns++
+			if row.Frame != frames[1].Id {
+				t.Errorf("spindown claim in frame %s", row.Frame)
+			}
+		}
+		if row.SpinUpClaim {
+			spinUps++
+		}
+		if row.Anomaly {
+			anomalies++
+			if row.Frame != frames[1].Id {
+				t.Errorf("anomaly in frame %s", row.Frame)
+			}
+		}
+	}
+	if spinDowns != 1 || spinUps != 1 || anomalies != 1 {
+		t.Errorf("got %d spindown claims, %d spinup claims and %d anomalies, want 1 of each", spinDowns, spinUps, anomalies)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const fanotifyMask = unix.FAN_OPEN | unix.FAN_ACCESS | unix.FAN_MODIFY | unix.FAN_CLOSE_WRITE
+
+var fanotifyMetadataLen = int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+
+type fileAccess struct {
+	Pid       string
+	Comm      string
+	Operation string
+	Path      string
+}
+
+var (
+	fileAccessMutex  sync.Mutex
+	fileAccesses     []fileAccess
+	fileAccessCounts map[fileAccess]int
+	fileAccessTracer *os.File
+)
+
+// startFileAccessTracing marks the mount points of the given disks with
+// fanotify. When fanotify is not available, inotify watches are set on the
+// mount points and their first level directories instead, which doesn't
+// report the pid of the process behind the access.
+func startFileAccessTracing(disks []string) error {
+	if len(disks) == 0 {
+		var err error
+		disks, err = physicalDisks()
+		if err != nil {
+			return err
+		}
+	}
+	mountPoints, err := diskMountPoints(disks)
+	if err != nil {
+		return err
+	}
+	if len(mountPoints) == 0 {
+		return fmt.Errorf("no mount points found for disks %s", strings.Join(disks, ", "))
+	}
+
+	fileAccessMutex.Lock()
+	fileAccesses = nil
+	fileAccessCounts = make(map[fileAccess]int)
+	fileAccessMutex.Unlock()
+
+	tracer, err := newFanotifyTracer(mountPoints)
+	if err == nil {
+		fileAccessTracer = tracer
+		go readFanotifyEvents(tracer)
+		log.Printf("Tracing file access with fanotify on %s", strings.Join(mountPoints, ", "))
+		return nil
+	}
+	log.Printf("Unable to use fanotify, falling back to inotify. %s", err)
+
+	tracer, watches, err := newInotifyTracer(mountPoints)
+	if err != nil {
+		return err
+	}
+	fileAccessTracer = tracer
+	go readInotifyEvents(tracer, watches)
+	log.Printf("Tracing file access with inotify on %s", strings.Join(mountPoints, ", "))
+	return nil
+}
+
+func stopFileAccessTracing() {
+	if fileAccessTracer == nil {
+		return
+	}
+	_ = fileAccessTracer.Close()
+	fileAccessTracer = nil
+}
+
+func collectFileAccess(frameDir string) error {
+	fileAccessMutex.Lock()
+	var content = ""
+	for _, access := range fileAccesses {
+		content += fmt.Sprintf("%s %s %s %s", access.Pid, access.Comm, access.Operation, access.Path)
+		if count := fileAccessCounts[access]; count > 1 {
+			content += fmt.Sprintf(" (x%d)", count)
+		}
+		content += "\n"
+	}
+	fileAccesses = nil
+	fileAccessCounts = make(map[fileAccess]int)
+	fileAccessMutex.Unlock()
+
+	return os.WriteFile(filepath.Join(frameDir, "access"), []byte(content), 0644)
+}
+
+func recordFileAccess(access fileAccess) {
+	fileAccessMutex.Lock()
+	defer fileAccessMutex.Unlock()
+	if fileAccessCounts == nil {
+		return
+	}
+	if fileAccessCounts[access] == 0 {
+		fileAccesses = append(fileAccesses, access)
+	}
+	fileAccessCounts[access]++
+}
+
+func newFanotifyTracer(mountPoints []string) (*os.File, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE)
+	if err != nil {
+		return nil, err
+	}
+	for _, mountPoint := range mountPoints {
+		err = unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, fanotifyMask, unix.AT_FDCWD, mountPoint)
+		if err != nil {
+			_ = unix.Close(fd)
+			return nil, fmt.Errorf("unable to mark %s. %w", mountPoint, err)
+		}
+	}
+	return os.NewFile(uintptr(fd), "fanotify"), nil
+}
+
+func readFanotifyEvents(tracer *os.File) {
+	ownPid := os.Getpid()
+	buffer := make([]byte, 4096)
+	for {
+		n, err := tracer.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+fanotifyMetadataLen <= n; {
+			event := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buffer[offset]))
+			if int(event.Event_len) < fanotifyMetadataLen {
+				break
+			}
+			offset += int(event.Event_len)
+			if event.Fd == unix.FAN_NOFD {
+				continue
+			}
+
+			path, _ := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", event.Fd))
+			_ = unix.Close(int(event.Fd))
+			if int(event.Pid) == ownPid {
+				continue
+			}
+			recordFileAccess(fileAccess{
+				Pid:       strconv.Itoa(int(event.Pid)),
+				Comm:      processComm(int(event.Pid)),
+				Operation: fanotifyOperation(event.Mask),
+				Path:      path,
+			})
+		}
+	}
+}
+
+func fanotifyOperation(mask uint64) string {
+	switch {
+	case mask&unix.FAN_MODIFY != 0:
+		return "write"
+	case mask&unix.FAN_CLOSE_WRITE != 0:
+		return "close_write"
+	case mask&unix.FAN_ACCESS != 0:
+		return "read"
+	default:
+		return "open"
+	}
+}
+
+func newInotifyTracer(mountPoints []string) (*os.File, map[int]string, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mask := uint32(unix.IN_OPEN | unix.IN_ACCESS | unix.IN_MODIFY | unix.IN_CLOSE_WRITE)
+	watches := make(map[int]string)
+	for _, mountPoint := range mountPoints {
+		dirs := []string{mountPoint}
+		entries, _ := os.ReadDir(mountPoint)
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, filepath.Join(mountPoint, e.Name()))
+			}
+		}
+		for _, dir := range dirs {
+			wd, err := unix.InotifyAddWatch(fd, dir, mask)
+			if err != nil {
+				log.Printf("Unable to watch %s. %s", dir, err)
+				continue
+			}
+			watches[wd] = dir
+		}
+	}
+	if len(watches) == 0 {
+		_ = unix.Close(fd)
+		return nil, nil, fmt.Errorf("unable to watch any of %s", strings.Join(mountPoints, ", "))
+	}
+	return os.NewFile(uintptr(fd), "inotify"), watches, nil
+}
+
+func readInotifyEvents(tracer *os.File, watches map[int]string) {
+	buffer := make([]byte, 4096*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := tracer.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := strings.TrimRight(string(buffer[nameStart:nameEnd]), "\x00")
+			offset = nameEnd
+
+			recordFileAccess(fileAccess{
+				Pid:       "-",
+				Comm:      "-",
+				Operation: inotifyOperation(event.Mask),
+				Path:      filepath.Join(watches[int(event.Wd)], name),
+			})
+		}
+	}
+}
+
+func inotifyOperation(mask uint32) string {
+	switch {
+	case mask&unix.IN_MODIFY != 0:
+		return "write"
+	case mask&unix.IN_CLOSE_WRITE != 0:
+		return "close_write"
+	case mask&unix.IN_ACCESS != 0:
+		return "read"
+	default:
+		return "open"
+	}
+}
+
+func processComm(pid int) string {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(comm))
+}
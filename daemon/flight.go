@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	flightTriggerFileName = "trigger.json"
+	defaultFlightMinutes  = 10
+	defaultFlightName     = "flight"
+)
+
+// flightTriggers are the conditions that save the flight recorder frames as
+// a session. A manual trigger is always possible through the API.
+var flightTriggers = []string{"power_up", "spinup", "diskstats"}
+
+// flightDir holds the frames of the flight recorder, outside of the data
+// directory so they aren't listed as a session.
+var flightDir = filepath.Join(os.TempDir(), "hdtd-flight")
+
+type flightOptions struct {
+	// Name is the name of the saved sessions
+	Name     string   `json:"name"`
+	Minutes  int      `json:"minutes"`
+	Triggers []string `json:"triggers"`
+	// Disks are the disks watched by the diskstats trigger, and the ones the
+	// collectors look at
+	Disks []string `json:"disks"`
+}
+
+type flightTrigger struct {
+	Reason string `json:"reason"`
+	Disk   string `json:"disk,omitempty"`
+	Time   string `json:"time"`
+}
+
+// flightRecorder collects frames continuously without a session, keeping the
+// last minutes of them. When a trigger fires the frames are saved as a
+// session and the recorder starts over. It is suspended while recording.
+type flightRecorder struct {
+	mutex     sync.Mutex
+	dataDir   string
+	options   flightOptions
+	frames    []string
+	last      *sessionFrame
+	suspended bool
+	sessions  []string
+}
+
+var (
+	flightMutex sync.Mutex
+	flight      *flightRecorder
+)
+
+func startFlightRecorder(dataDir string, options flightOptions) error {
+	if options.Name == "" {
+		options.Name = defaultFlightName
+	}
+	if options.Minutes <= 0 {
+		options.Minutes = defaultFlightMinutes
+	}
+	for _, trigger := range options.Triggers {
+		if !isFlightTrigger(trigger) {
+			return fmt.Errorf("unknown trigger '%s', expected one of %s", trigger, strings.Join(flightTriggers, ", "))
+		}
+		if trigger == "diskstats" && len(options.Disks) == 0 {
+			return fmt.Errorf("the diskstats trigger needs the disks to watch")
+		}
+	}
+
+	flightMutex.Lock()
+	defer flightMutex.Unlock()
+	if flight != nil {
+		return fmt.Errorf("flight recorder already running")
+	}
+	if isCollecting() {
+		return fmt.Errorf("recording in progress")
+	}
+	if err := os.RemoveAll(flightDir); err != nil {
+		return err
+	}
+	f := &flightRecorder{dataDir: dataDir, options: options}
+	if err := f.resume(); err != nil {
+		return err
+	}
+	flight = f
+	log.Printf("Flight recorder keeping the last %d minutes, triggers: %s", options.Minutes, strings.Join(options.Triggers, ", "))
+	return nil
+}
+
+func stopFlightRecorder() {
+	flightMutex.Lock()
+	defer flightMutex.Unlock()
+	if flight == nil {
+		return
+	}
+	if !flight.suspended {
+		stopCollecting()
+	}
+	flight = nil
+	if err := os.RemoveAll(flightDir); err != nil {
+		log.Println(err)
+	}
+	log.Println("Flight recorder stopped")
+}
+
+// suspendFlightRecorder gives the collection over to a recording.
+func suspendFlightRecorder() {
+	flightMutex.Lock()
+	defer flightMutex.Unlock()
+	if flight == nil || flight.suspended {
+		return
+	}
+	stopCollecting()
+	flight.suspended = true
+}
+
+// resumeFlightRecorder takes the collection back once a recording stops.
+func resumeFlightRecorder() {
+	flightMutex.Lock()
+	defer flightMutex.Unlock()
+	if flight == nil || !flight.suspended {
+		return
+	}
+	if err := flight.resume(); err != nil {
+		log.Printf("Unable to resume the flight recorder. %s", err)
+	}
+}
+
+func (f *flightRecorder) resume() error {
+	hdidleStdoutLength = logNotRead
+	hdidleLogLength = logNotRead
+	resetProcIO()
+	diskstatsProvider.restart()
+	powerProvider.restart()
+	syncClock()
+
+	// the frames from before a recording don't follow on from the ones to
+	// come, the counters and the log offsets having started over
+	f.mutex.Lock()
+	for _, frameDir := range f.frames {
+		if err := os.RemoveAll(frameDir); err != nil {
+			log.Println(err)
+		}
+	}
+	f.frames = nil
+	f.last = nil
+	f.suspended = false
+	f.mutex.Unlock()
+	return startCollecting(f.collect)
+}
+
+// triggerFlightRecorder saves the frames kept by the flight recorder as a
+// session.
+func triggerFlightRecorder(reason string) (string, error) {
+	flightMutex.Lock()
+	f := flight
+	flightMutex.Unlock()
+	if f == nil {
+		return "", fmt.Errorf("flight recorder not running")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.save(flightTrigger{Reason: reason, Time: clockNow().Format(time.RFC3339)})
+}
+
+func (f *flightRecorder) collect() error {
+	now := clockNow()
+	frameDir := filepath.Join(flightDir, fmt.Sprintf("%d", now.Unix()))
+	if err := collectFrame(frameDir, "", recordOptions{Disks: f.options.Disks}); err != nil {
+		return err
+	}
+	frame, err := loadSessionFrame(frameDir, now)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.frames = append(f.frames, frameDir)
+	for len(f.frames) > f.options.Minutes*int(time.Minute/frameInterval) {
+		if err = os.RemoveAll(f.frames[0]); err != nil {
+			log.Println(err)
+		}
+		f.frames = f.frames[1:]
+	}
+
+	previous := f.last
+	f.last = &frame
+	if previous == nil {
+		return nil
+	}
+	if trigger, fired := f.fired(*previous, frame); fired {
+		trigger.Time = now.Format(time.RFC3339)
+		if _, err = f.save(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fired checks the enabled triggers against the last two frames.
+func (f *flightRecorder) fired(previous, current sessionFrame) (flightTrigger, bool) {
+	for _, trigger := range f.options.Triggers {
+		switch trigger {
+		case "power_up":
+			for disk, state := range current.Power {
+				if previous.Power[disk] == "down" && state == "up" {
+					return flightTrigger{Reason: trigger, Disk: disk}, true
+				}
+			}
+			for disk, state := range current.PowerMode {
+				if previous.PowerMode[disk] == "standby" && knownPowerState(state) && state != "standby" {
+					return flightTrigger{Reason: trigger, Disk: disk}, true
+				}
+			}
+		case "spinup":
+			// hd-idle only writes to its log when a disk spins up, and the
+			// log of a frame only holds the lines added in it
+			if strings.TrimSpace(current.Log) != "" {
+				return flightTrigger{Reason: trigger}, true
+			}
+			for _, claim := range hdIdleClaims([]sessionFrame{current}, currentDiskMapping()) {
+				if claim.Kind == "up" {
+					return flightTrigger{Reason: trigger, Disk: claim.Disk}, true
+				}
+			}
+		case "diskstats":
+			for _, disk := range f.options.Disks {
+				stat, ok := current.Diskstats[disk]
+				if ok && stat.activeSince(previous.Diskstats[disk]) {
+					return flightTrigger{Reason: trigger, Disk: disk}, true
+				}
+			}
+		}
+	}
+	return flightTrigger{}, false
+}
+
+// save moves the frames kept so far into a new session, along with the
+// trigger, the disk topology and mapping and the environment.
+func (f *flightRecorder) save(trigger flightTrigger) (string, error) {
+	if len(f.frames) == 0 {
+		return "", fmt.Errorf("no frames recorded yet")
+	}
+	sessionDir := filepath.Join(f.dataDir, fmt.Sprintf("%s;%d", f.options.Name, clockNow().Unix()))
+	for _, frameDir := range f.frames {
+		if err := copyFrame(frameDir, filepath.Join(sessionDir, filepath.Base(frameDir))); err != nil {
+			return "", err
+		}
+	}
+	if err := saveTopology(sessionDir); err != nil {
+		log.Printf("Unable to save disk topology. %s", err)
+	}
+	if err := updateSessionDiskMapping(sessionDir, currentDiskMapping()); err != nil {
+		log.Printf("Unable to save disk mapping. %s", err)
+	}
+	if err := saveEnvironment(sessionDir, environmentStartFileName); err != nil {
+		log.Printf("Unable to save environment. %s", err)
+	}
+	content, err := json.Marshal(trigger)
+	if err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(sessionDir, flightTriggerFileName), content, 0644); err != nil {
+		return "", err
+	}
+
+	for _, frameDir := range f.frames {
+		if err = os.RemoveAll(frameDir); err != nil {
+			log.Println(err)
+		}
+	}
+	f.frames = nil
+	session := filepath.Base(sessionDir)
+	f.sessions = append(f.sessions, session)
+	log.Printf("Flight recorder triggered by %s, saved session '%s'", strings.TrimSpace(trigger.Reason+" "+trigger.Disk), session)
+	return session, nil
+}
+
+// copyFrame copies the files of a frame, the flight directory being usually
+// on another file system than the sessions.
+func copyFrame(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dst, 0750); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err = copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func isFlightTrigger(trigger string) bool {
+	for _, t := range flightTriggers {
+		if t == trigger {
+			return true
+		}
+	}
+	return false
+}
+
+// flightStatus describes the flight recorder for GET /flight.
+type flightStatus struct {
+	Running   bool           `json:"running"`
+	Suspended bool           `json:"suspended"`
+	Options   *flightOptions `json:"options,omitempty"`
+	Frames    int            `json:"frames"`
+	Sessions  []string       `json:"sessions"`
+}
+
+func flightRecorderStatus() flightStatus {
+	flightMutex.Lock()
+	f := flight
+	flightMutex.Unlock()
+	if f == nil {
+		return flightStatus{Sessions: []string{}}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	options := f.options
+	return flightStatus{
+		Running:   true,
+		Suspended: f.suspended,
+		Options:   &options,
+		Frames:    len(f.frames),
+		Sessions:  append([]string{}, f.sessions...),
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFlightRecorderSpinUpTrigger(t *testing.T) {
+	f := &flightRecorder{options: flightOptions{Triggers: []string{"spinup"}}}
+	for _, test := range []struct {
+		name         string
+		log, stdout  string
+		fired        bool
+		expectedDisk string
+	}{
+		{"no new lines", "", "", false, ""},
+		{"blank lines", "\n", "", false, ""},
+		{"new log line", "date: 2026-10-19T03:00:00 sda spinup\n", "", true, ""},
+		{"spinup claim", "", "sda spinup\n", true, "sda"},
+		{"spindown claim", "", "sda spindown\n", false, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, fired := f.fired(sessionFrame{}, sessionFrame{Log: test.log, Stdout: test.stdout})
+			if fired != test.fired || trigger.Disk != test.expectedDisk {
+				t.Errorf("got %+v %t, want fired %t on '%s'", trigger, fired, test.fired, test.expectedDisk)
+			}
+		})
+	}
+}
@@ -2,40 +2,82 @@ package main
 
 import (
 	"bufio"
-	"context"
-	"encoding/json"
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/madflojo/tasks"
 )
 
 const (
-	socketFile          = "/tmp/hdtd.sock"
-	hdidleLogFile       = "/var/log/hd-idle.log"
-	hdidleStdoutFile    = "/tmp/hd-idle.out"
-	diskMappingFileName = "disk_mapping.txt"
+	defaultSocketFile = "/tmp/hdtd.sock"
+	hdidleLogFile     = "/var/log/hd-idle.log"
+	hdidleStdoutFile  = "/tmp/hd-idle.out"
+	// logNotRead is the length of a log not read yet in the recording, as
+	// opposed to an empty one
+	logNotRead = -1
 )
 
+// errRecording is returned when a recording starts while another one is in
+// progress, e.g. started by a trigger.
+var errRecording = errors.New("a recording is in progress")
+
+// recordOptions tune which collectors run during a recording.
+type recordOptions struct {
+	Disks      []string       `json:"disks"`
+	ProcIO     bool           `json:"procio"`
+	FileAccess bool           `json:"fileaccess"`
+	BlockTrace bool           `json:"blocktrace"`
+	PowerMode  bool           `json:"powermode"`
+	HdIdle     *hdIdleOptions `json:"hdidle"`
+}
+
 var (
 	recording          = make(chan bool, 1)
-	hdidleStdoutLength = 0
-	hdidleLogLength    = 0
+	hdidleStdoutLength = logNotRead
+	hdidleLogLength    = logNotRead
+	currentSessionDir  = ""
+	recordingMutex     sync.Mutex
 )
 
 func main() {
+	var diskstatsPath, spdSocket, script, replay, simulator string
+	var virtualTime, drivePowerMode bool
+	var metricsAddress, socketFile string
+	flag.StringVar(&socketFile, "socket", defaultSocketFile, "socket to serve the API on")
+	flag.StringVar(&tracefsDir, "tracefs", tracefsDir, "tracefs mount point used for block request tracing")
+	flag.StringVar(&diskstatsPath, "diskstats", "", "file or directory to read the diskstats from instead of /proc/diskstats")
+	flag.StringVar(&spdSocket, "spd", "", "socket of the spd API providing the power state (default "+spdSocketFile+")")
+	flag.BoolVar(&drivePowerMode, "drive-power", false, "take the power state from the power mode reported by the drives instead of spd")
+	flag.StringVar(&script, "script", "", "script generating the diskstats and power state, e.g. \"sda: idle 600s then 5 writes\"")
+	flag.StringVar(&replay, "replay", "", "session directory to replay the diskstats and power state from")
+	flag.BoolVar(&virtualTime, "virtual", false, "record on a virtual clock moved with POST /clock instead of the wall clock")
+	flag.StringVar(&simulator, "simulator", "", "control socket of the hd-idle simulator sharing the virtual clock, implies -virtual")
+	flag.StringVar(&metricsAddress, "metrics", "", "address to serve GET /metrics on for Prometheus, e.g. :9101, besides the socket")
+	flag.DurationVar(&verifyWindow, "verify-window", defaultVerifyWindow, "time allowed between an hd-idle claim and the power state confirming it")
+	flag.Parse()
+
+	if virtualTime || simulator != "" {
+		setupVirtualClock(simulator)
+	}
+
 	router := gin.Default()
 
-	scheduler := tasks.New()
-	defer scheduler.Stop()
+	defer stopCollecting()
 
 	configDir, _ := os.UserConfigDir()
 
@@ -45,13 +87,35 @@ func main() {
 		panic(err)
 	}
 
-	_, err = os.Stat(filepath.Join(dataDir, diskMappingFileName))
-	if os.IsNotExist(err) {
-		file, err := os.Create(filepath.Join(dataDir, diskMappingFileName))
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
+	if err = setupSources(diskstatsPath, spdSocket, drivePowerMode, script, replay); err != nil {
+		panic(err)
+	}
+
+	recoverBlockTracing(dataDir)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		stopFileAccessTracing()
+		stopBlockTracing()
+		stopHdIdle()
+		os.Exit(0)
+	}()
+
+	refreshDiskMapping()
+	go watchUdevChanges()
+	loadRecordTriggers(dataDir)
+	loadNotifiers(dataDir)
+
+	router.GET("/metrics", serveMetrics)
+	if metricsAddress != "" {
+		go func() {
+			metricsRouter := gin.New()
+			metricsRouter.GET("/metrics", serveMetrics)
+			if err := http.ListenAndServe(metricsAddress, metricsRouter); err != nil {
+				log.Printf("Unable to serve metrics on %s. %s", metricsAddress, err)
+			}
+		}()
 	}
 
 	router.GET("/sessions", func(c *gin.Context) {
@@ -82,14 +146,21 @@ func main() {
 		sessionDir := filepath.Join(dataDir, c.Param("id"))
 
 		type Frame struct {
-			Id        string `json:"id"`
-			Diskstats string `json:"diskstats"`
-			Log       string `json:"log"`
-			Stdout    string `json:"stdout"`
-			Power     string `json:"power"`
+			Id         string `json:"id"`
+			Diskstats  string `json:"diskstats"`
+			Log        string `json:"log"`
+			Stdout     string `json:"stdout"`
+			Power      string `json:"power"`
+			Sysfs      string `json:"sysfs"`
+			ProcIO     string `json:"procio"`
+			Access     string `json:"access"`
+			BlockTrace string `json:"blocktrace"`
+			PowerMode  string `json:"powermode"`
 		}
 		type Response struct {
-			Frames []Frame `json:"frames"`
+			Frames      []Frame           `json:"frames"`
+			Topology    []diskTopology    `json:"topology"`
+			DiskMapping map[string]string `json:"disk_mapping"`
 		}
 
 		frameDirs, err := os.ReadDir(sessionDir)
@@ -129,57 +200,456 @@ func main() {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			// sysfs, procio, access, blocktrace and powermode are optional, older
+			// sessions and recordings without the optional collectors don't have them
+			sysfsBytes, _ := os.ReadFile(filepath.Join(sessionDir, e.Name(), "sysfs"))
+			procIOBytes, _ := os.ReadFile(filepath.Join(sessionDir, e.Name(), "procio"))
+			accessBytes, _ := os.ReadFile(filepath.Join(sessionDir, e.Name(), "access"))
+			blocktraceBytes, _ := os.ReadFile(filepath.Join(sessionDir, e.Name(), "blocktrace"))
+			powerModeBytes, _ := os.ReadFile(filepath.Join(sessionDir, e.Name(), "powermode"))
 			frames = append(frames, Frame{
-				Id:        e.Name(),
-				Diskstats: string(diskStatsBytes),
-				Log:       string(logBytes),
-				Stdout:    string(stdoutBytes),
-				Power:     string(powerBytes),
+				Id:         e.Name(),
+				Diskstats:  string(diskStatsBytes),
+				Log:        string(logBytes),
+				Stdout:     string(stdoutBytes),
+				Power:      string(powerBytes),
+				Sysfs:      string(sysfsBytes),
+				ProcIO:     string(procIOBytes),
+				Access:     string(accessBytes),
+				BlockTrace: string(blocktraceBytes),
+				PowerMode:  string(powerModeBytes),
 			})
 		}
 
-		c.JSON(http.StatusOK, Response{Frames: frames})
+		topology, err := sessionTopology(sessionDir)
+		if err != nil {
+			log.Println(err)
+		}
+
+		mapping, err := sessionDiskMapping(sessionDir)
+		if err != nil {
+			mapping = legacyDiskMapping(dataDir)
+		}
+
+		c.JSON(http.StatusOK, Response{Frames: frames, Topology: topology, DiskMapping: mapping})
 	})
 
-	router.GET("/status", func(c *gin.Context) {
-		taskLen := len(scheduler.Tasks())
+	router.GET("/sessions/:id/activity", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+
+		type Frame struct {
+			Id       string `json:"id"`
+			Activity string `json:"activity"`
+		}
+		type Response struct {
+			Topology []diskTopology `json:"topology"`
+			Frames   []Frame        `json:"frames"`
+		}
+
+		topology, err := sessionTopology(sessionDir)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		frameDirs, err := os.ReadDir(sessionDir)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var frames []Frame
+		var previous map[string]diskStat
+		for _, e := range frameDirs {
+			if !e.IsDir() {
+				continue
+			}
+			diskStatsBytes, err := os.ReadFile(filepath.Join(sessionDir, e.Name(), "diskstats"))
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			current := parseDiskstats(string(diskStatsBytes))
+			if previous != nil {
+				frames = append(frames, Frame{Id: e.Name(), Activity: diskActivity(topology, previous, current)})
+			}
+			previous = current
+		}
+
+		c.JSON(http.StatusOK, Response{Topology: topology, Frames: frames})
+	})
+
+	router.GET("/sessions/:id/environment", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+
+		type Response struct {
+			Start *environment `json:"start"`
+			End   *environment `json:"end"`
+		}
+
+		start, err := sessionEnvironment(sessionDir, environmentStartFileName)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		end, err := sessionEnvironment(sessionDir, environmentEndFileName)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Start: start, End: end})
+	})
+
+	router.GET("/sessions/:id/hdidle", func(c *gin.Context) {
+		type Response struct {
+			Runs []hdIdleRun `json:"runs"`
+		}
+
+		runs, err := sessionHdIdleRuns(filepath.Join(dataDir, c.Param("id")))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Runs: runs})
+	})
+
+	router.GET("/sessions/:id/markers", func(c *gin.Context) {
+		type Response struct {
+			Markers []marker `json:"markers"`
+		}
+
+		markers, err := sessionMarkers(filepath.Join(dataDir, c.Param("id")))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Markers: markers})
+	})
+
+	router.POST("/markers", func(c *gin.Context) {
+		var request marker
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		session := recordingSession()
+		if session == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "not recording"})
+			return
+		}
+		if err := addMarker(filepath.Join(dataDir, session), request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	router.GET("/sessions/:id/events", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+
+		type Response struct {
+			Window int64          `json:"window"`
+			Events []sessionEvent `json:"events"`
+		}
+
+		window := verifyWindow
+		if seconds, err := strconv.Atoi(c.Query("window")); err == nil && seconds > 0 {
+			window = time.Duration(seconds) * time.Second
+		}
+
+		frames, err := loadSessionFrames(sessionDir)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		mapping, err := sessionDiskMapping(sessionDir)
+		if err != nil {
+			mapping = legacyDiskMapping(dataDir)
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Window: int64(window / time.Second),
+			Events: verifySession(frames, mapping, window),
+		})
+	})
+
+	router.GET("/sessions/:id/summary", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
 
-		diskMappingFile, err := os.Open(filepath.Join(dataDir, diskMappingFileName))
+		type Response struct {
+			Verdict *verdict       `json:"verdict"`
+			Disks   []diskAnalysis `json:"disks"`
+		}
+
+		frames, err := loadSessionFrames(sessionDir)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		v, err := sessionVerdict(sessionDir)
 		if err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		defer diskMappingFile.Close()
 
-		mapping := make(map[string]string)
-		scanner := bufio.NewScanner(diskMappingFile)
-		for scanner.Scan() {
-			mappingParts := strings.Split(scanner.Text(), ":")
-			mapping[mappingParts[0]] = mappingParts[1]
+		c.JSON(http.StatusOK, Response{Verdict: v, Disks: analyzeSession(frames)})
+	})
+
+	router.GET("/sessions/:id/stats", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+
+		type Response struct {
+			Disks []diskStatistics `json:"disks"`
 		}
 
-		if err := scanner.Err(); err != nil {
+		frames, err := loadSessionFrames(sessionDir)
+		if err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		mapping, err := sessionDiskMapping(sessionDir)
+		if err != nil {
+			mapping = legacyDiskMapping(dataDir)
+		}
+		idleTimes, err := sessionIdleTimes(sessionDir, mapping)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Disks: sessionStatistics(frames, idleTimes)})
+	})
 
+	router.GET("/sessions/:id/export", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+
+		format := c.DefaultQuery("format", "csv")
+		contentType, ok := exportFormats[format]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, jsonl or influx"})
+			return
+		}
+		var devices []string
+		if c.Query("disks") != "" {
+			devices = strings.Split(c.Query("disks"), ",")
+		}
+
+		frames, err := loadSessionFrames(sessionDir)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		topology, err := sessionTopology(sessionDir)
+		if err != nil {
+			log.Println(err)
+		}
+		mapping, err := sessionDiskMapping(sessionDir)
+		if err != nil {
+			mapping = legacyDiskMapping(dataDir)
+		}
+
+		var buffer bytes.Buffer
+		if err = writeExport(&buffer, format, c.Param("id"), sessionExportRows(frames, topology, mapping, devices)); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		fileName := strings.ReplaceAll(c.Param("id"), ";", "_") + "." + format
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+		c.Data(http.StatusOK, contentType, buffer.Bytes())
+	})
+
+	router.GET("/sessions/:id/report", func(c *gin.Context) {
+		report, err := buildSessionReport(dataDir, c.Param("id"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		var buffer bytes.Buffer
+		if err = writeSessionReport(&buffer, report); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		fileName := strings.ReplaceAll(c.Param("id"), ";", "_") + ".html"
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buffer.Bytes())
+	})
+
+	router.POST("/compare", func(c *gin.Context) {
+		type Request struct {
+			Sessions   []string          `json:"sessions"`
+			Marker     string            `json:"marker"`
+			Tolerances compareTolerances `json:"tolerances"`
+		}
+		type Response struct {
+			Baseline    string            `json:"baseline"`
+			Tolerances  compareTolerances `json:"tolerances"`
+			Sessions    []comparedSession `json:"sessions"`
+			Regressions []regression      `json:"regressions"`
+		}
+
+		request := Request{Tolerances: defaultCompareTolerances}
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(request.Sessions) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least two sessions are needed"})
+			return
+		}
+
+		var sessions []comparedSession
+		for _, id := range request.Sessions {
+			if _, err := os.Stat(filepath.Join(dataDir, id)); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session '%s' not found", id)})
+				return
+			}
+			session, err := loadComparedSession(dataDir, id, request.Marker)
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+			sessions = append(sessions, session)
+		}
+
+		c.JSON(http.StatusOK, Response{
+			Baseline:    request.Sessions[0],
+			Tolerances:  request.Tolerances,
+			Sessions:    sessions,
+			Regressions: compareSessions(sessions, request.Tolerances),
+		})
+	})
+
+	router.GET("/topology", func(c *gin.Context) {
+		type Response struct {
+			Topology []diskTopology `json:"topology"`
+		}
+
+		topology, err := buildTopology()
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Topology: topology})
+	})
+
+	router.GET("/status", func(c *gin.Context) {
 		type Response struct {
 			Recording   bool              `json:"recording"`
+			Replaying   string            `json:"replaying"`
+			Flight      bool              `json:"flight"`
 			DiskMapping map[string]string `json:"disk_mapping"`
 		}
 
-		rec := false
-		if taskLen > 0 {
-			rec = true
-		}
 		c.JSON(http.StatusOK,
-			Response{Recording: rec,
-				DiskMapping: mapping,
+			Response{Recording: currentSessionDir != "",
+				Replaying:   replayingSession(),
+				Flight:      flightRecorderStatus().Running,
+				DiskMapping: currentDiskMapping(),
 			})
 	})
 
+	router.GET("/clock", func(c *gin.Context) {
+		type Response struct {
+			Now     string `json:"now"`
+			Virtual bool   `json:"virtual"`
+		}
+		syncClock()
+		c.JSON(http.StatusOK, Response{Now: clockNow().Format(time.RFC3339), Virtual: virtual != nil})
+	})
+
+	router.POST("/clock", func(c *gin.Context) {
+		type Request struct {
+			Advance int64 `json:"advance"`
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if virtual == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "the daemon runs on the wall clock"})
+			return
+		}
+		if err := virtual.advance(time.Duration(request.Advance) * time.Second); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"now": clockNow().Format(time.RFC3339)})
+	})
+
+	router.GET("/stream", func(c *gin.Context) {
+		frames := subscribeFrames()
+		defer unsubscribeFrames(frames)
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case frame := <-frames:
+				c.SSEvent("frame", frame)
+				return true
+			}
+		})
+	})
+
+	router.POST("/sessions/:id/replay", func(c *gin.Context) {
+		type Request struct {
+			Action string  `json:"action"`
+			Speed  float64 `json:"speed"`
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch request.Action {
+		case "start":
+			if request.Speed <= 0 {
+				request.Speed = 1
+			}
+			if err := startReplay(filepath.Join(dataDir, c.Param("id")), request.Speed); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		case "stop":
+			stopReplay()
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be start or stop"})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
 	router.GET("/record", func(c *gin.Context) {
 		type Response struct {
 			Recording bool `json:"recording"`
@@ -195,6 +665,7 @@ func main() {
 		type Request struct {
 			Name   string `json:"name"`
 			Action string `json:"action"`
+			recordOptions
 		}
 		var request Request
 		err := c.ShouldBind(&request)
@@ -203,35 +674,178 @@ func main() {
 			return
 		}
 
+		type Response struct {
+			Session string `json:"session"`
+		}
+		var response Response
+
 		if request.Action == "start" {
-			recording <- true
-			hdidleStdoutLength = 0
-			hdidleLogLength = 0
-			sessionDir := filepath.Join(dataDir, fmt.Sprintf("%d", time.Now().Unix()))
-			if len(request.Name) > 0 {
-				sessionDir = filepath.Join(dataDir, fmt.Sprintf("%s;%d", request.Name, time.Now().Unix()))
+			response.Session, err = startRecording(dataDir, request.Name, request.recordOptions)
+			if errors.Is(err, errRecording) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "session": recordingSession()})
+				return
 			}
-			_, err = scheduler.Add(&tasks.Task{
-				Interval:          5 * time.Second,
-				RunSingleInstance: true,
-				TaskFunc: func() error {
-					return collectStats(dataDir, sessionDir)
-				},
-			})
 			if err != nil {
-				panic(err)
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
 			}
-			log.Printf("Starting recording '%s'...", request.Name)
 		}
 		if request.Action == "stop" {
-			recording <- false
-			scheduler.Stop()
-			log.Printf("Stopping recording '%s'...", request.Name)
+			response.Session = stopRecording(request.Name)
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+
+	router.GET("/triggers", func(c *gin.Context) {
+		type Response struct {
+			Triggers []recordTriggerStatus `json:"triggers"`
+		}
+
+		c.JSON(http.StatusOK, Response{Triggers: listRecordTriggers()})
+	})
+
+	router.POST("/triggers", func(c *gin.Context) {
+		var request recordTrigger
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := addRecordTrigger(request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	router.DELETE("/triggers/:name", func(c *gin.Context) {
+		found, err := removeRecordTrigger(c.Param("name"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "trigger not found"})
+			return
 		}
 
 		c.Status(http.StatusOK)
 	})
 
+	router.GET("/notifiers", func(c *gin.Context) {
+		type Response struct {
+			Notifiers []notifierStatus `json:"notifiers"`
+		}
+
+		c.JSON(http.StatusOK, Response{Notifiers: listNotifiers()})
+	})
+
+	router.POST("/notifiers", func(c *gin.Context) {
+		var request notifier
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := addNotifier(request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	router.DELETE("/notifiers/:name", func(c *gin.Context) {
+		found, err := removeNotifier(c.Param("name"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "notifier not found"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	router.GET("/flight", func(c *gin.Context) {
+		c.JSON(http.StatusOK, flightRecorderStatus())
+	})
+
+	router.POST("/flight", func(c *gin.Context) {
+		type Request struct {
+			Action string `json:"action"`
+			flightOptions
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch request.Action {
+		case "start":
+			if err := startFlightRecorder(dataDir, request.flightOptions); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+		case "stop":
+			stopFlightRecorder()
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be start or stop"})
+			return
+		}
+
+		c.JSON(http.StatusOK, flightRecorderStatus())
+	})
+
+	router.POST("/flight/trigger", func(c *gin.Context) {
+		type Response struct {
+			Session string `json:"session"`
+		}
+
+		session, err := triggerFlightRecorder("manual")
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, Response{Session: session})
+	})
+
+	router.POST("/sessions/:id/verdict", func(c *gin.Context) {
+		sessionDir := filepath.Join(dataDir, c.Param("id"))
+		if _, err := os.Stat(sessionDir); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+
+		var request verdict
+		if err := c.ShouldBind(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := saveVerdict(sessionDir, request); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		go notifyVerdict(sessionDir, request)
+
+		c.Status(http.StatusOK)
+	})
+
 	_ = os.Remove(socketFile)
 	listener, err := net.Listen("unix", socketFile)
 	if err != nil {
@@ -247,189 +861,293 @@ func main() {
 	}
 }
 
-func collectStats(dataDir, sessionDir string) error {
-	frameDir := filepath.Join(sessionDir, fmt.Sprintf("%d", time.Now().Unix()))
-	err := os.MkdirAll(frameDir, 0750)
+// notifyRecording replaces the recording state not yet read by the TUI, so
+// starting and stopping recordings doesn't block when no TUI is listening.
+func notifyRecording(state bool) {
+	select {
+	case <-recording:
+	default:
+	}
+	recording <- state
+}
+
+// startRecording starts a session named after the name and the current time
+// and collects a frame every frame interval until stopRecording.
+func startRecording(dataDir, name string, options recordOptions) (string, error) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if currentSessionDir != "" {
+		return "", errRecording
+	}
+
+	suspendFlightRecorder()
+	notifyRecording(true)
+	hdidleStdoutLength = logNotRead
+	hdidleLogLength = logNotRead
+	resetProcIO()
+	diskstatsProvider.restart()
+	powerProvider.restart()
+	syncClock()
+	sessionDir := filepath.Join(dataDir, fmt.Sprintf("%d", clockNow().Unix()))
+	if len(name) > 0 {
+		sessionDir = filepath.Join(dataDir, fmt.Sprintf("%s;%d", name, clockNow().Unix()))
+	}
+	err := saveTopology(sessionDir)
+	if err != nil {
+		log.Printf("Unable to save disk topology. %s", err)
+	}
+	if err = snapshotDiskMapping(sessionDir); err != nil {
+		log.Printf("Unable to save disk mapping. %s", err)
+	}
+	if err = saveEnvironment(sessionDir, environmentStartFileName); err != nil {
+		log.Printf("Unable to save environment. %s", err)
+	}
+	currentSessionDir = sessionDir
+	if options.HdIdle != nil {
+		if err = startHdIdle(sessionDir, *options.HdIdle); err != nil {
+			log.Printf("Unable to start hd-idle. %s", err)
+		}
+	}
+	if options.FileAccess {
+		if err = startFileAccessTracing(options.Disks); err != nil {
+			log.Printf("File access tracing disabled. %s", err)
+			options.FileAccess = false
+		}
+	}
+	if options.BlockTrace {
+		if err = startBlockTracing(dataDir, options.Disks); err != nil {
+			log.Printf("Block request tracing disabled. %s", err)
+			options.BlockTrace = false
+		}
+	}
+	err = startCollecting(func() error {
+		return collectStats(dataDir, sessionDir, options)
+	})
 	if err != nil {
+		return "", err
+	}
+	log.Printf("Starting recording '%s'...", name)
+	return filepath.Base(sessionDir), nil
+}
+
+// recordingSession returns the session being recorded, if any.
+func recordingSession() string {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if currentSessionDir == "" {
+		return ""
+	}
+	return filepath.Base(currentSessionDir)
+}
+
+// stopRecording stops the current session and returns it.
+func stopRecording(name string) string {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+
+	notifyRecording(false)
+	stopped := stopCollecting()
+	stopFileAccessTracing()
+	stopBlockTracing()
+	stopDiskMappingSnapshot()
+	stopHdIdle()
+	session := ""
+	if currentSessionDir != "" {
+		if err := saveEnvironment(currentSessionDir, environmentEndFileName); err != nil {
+			log.Printf("Unable to save environment. %s", err)
+		}
+		session = filepath.Base(currentSessionDir)
+		// wait for the last frame in the background, not to hold
+		// recordingMutex while it is collected
+		go func(sessionDir string) {
+			if stopped != nil {
+				stopped.wait()
+			}
+			notifyRecordingFinished(sessionDir)
+		}(currentSessionDir)
+		currentSessionDir = ""
+	}
+	log.Printf("Stopping recording '%s'...", name)
+	resumeFlightRecorder()
+	return session
+}
+
+func collectStats(dataDir, sessionDir string, options recordOptions) error {
+	frameDir := filepath.Join(sessionDir, fmt.Sprintf("%d", clockNow().Unix()))
+	if err := collectFrame(frameDir, filepath.Base(sessionDir), options); err != nil {
 		return err
 	}
+	watchSpuriousSpinUps(sessionDir, frameDir)
 
-	err = collectDiskstats(frameDir)
+	frame, err := readStreamFrame(filepath.Base(sessionDir), frameDir)
 	if err != nil {
 		return err
 	}
-	err = collectHdIdleLog(dataDir, frameDir)
+	publishFrame(frame)
+	return nil
+}
+
+// collectFrame runs every collector enabled in the options into frameDir.
+// session is the one recorded, empty for the flight recorder. It is given
+// rather than read with recordingSession, which would take recordingMutex
+// while the virtual clock is advancing.
+func collectFrame(frameDir, session string, options recordOptions) error {
+	start := time.Now()
+	defer func() {
+		metrics.observeFrame(time.Since(start))
+		endCollectorStreaks()
+	}()
+
+	err := os.MkdirAll(frameDir, 0750)
 	if err != nil {
 		return err
 	}
-	err = collectHdIdleStdout(dataDir, frameDir)
+
+	err = collectDiskstats(frameDir)
 	if err != nil {
-		return err
+		return collectorFailed(session, "diskstats", err)
+	}
+	err = collectHdIdleLog(frameDir)
+	if err != nil {
+		return collectorFailed(session, "log", err)
+	}
+	err = collectHdIdleStdout(frameDir)
+	if err != nil {
+		return collectorFailed(session, "stdout", err)
+	}
+	countHdIdleEvents(frameDir)
+	err = collectSysfs(frameDir, options.Disks)
+	if err != nil {
+		return collectorFailed(session, "sysfs", err)
+	}
+	if options.ProcIO {
+		err = collectProcIO(frameDir, options.Disks)
+		if err != nil {
+			return collectorFailed(session, "procio", err)
+		}
+	}
+	if options.FileAccess {
+		err = collectFileAccess(frameDir)
+		if err != nil {
+			return collectorFailed(session, "access", err)
+		}
+	}
+	if options.BlockTrace {
+		err = collectBlockTrace(frameDir)
+		if err != nil {
+			return collectorFailed(session, "blocktrace", err)
+		}
 	}
 	err = collectPowerState(frameDir)
 	if err != nil {
-		return err
+		return collectorFailed(session, "power", err)
+	}
+	if options.PowerMode {
+		err = collectPowerMode(frameDir, options.Disks)
+		if err != nil {
+			return collectorFailed(session, "powermode", err)
+		}
 	}
-
 	return nil
 }
 
 func collectDiskstats(frameDir string) error {
-	bytesRead, err := os.ReadFile("/proc/diskstats")
+	bytesRead, err := diskstatsProvider.diskstats()
 	if err != nil {
 		return err
 	}
+	metrics.setDiskstats(parseDiskstats(string(bytesRead)))
 
 	return os.WriteFile(filepath.Join(frameDir, "diskstats"), bytesRead, 0644)
 }
 
-func collectHdIdleLog(dataDir, frameDir string) error {
-	return collectLog(dataDir, hdidleLogFile, filepath.Join(frameDir, "log"), &hdidleLogLength)
+func collectHdIdleLog(frameDir string) error {
+	_, logPath := currentSupervisor()
+	return collectLog(logPath, filepath.Join(frameDir, "log"), &hdidleLogLength)
 }
 
-func collectHdIdleStdout(dataDir, frameDir string) error {
-	return collectLog(dataDir, hdidleStdoutFile, filepath.Join(frameDir, "stdout"), &hdidleStdoutLength)
+func collectHdIdleStdout(frameDir string) error {
+	if s, _ := currentSupervisor(); s != nil {
+		return os.WriteFile(filepath.Join(frameDir, "stdout"), []byte(s.drainOutput()), 0644)
+	}
+	return collectLog(hdidleStdoutFile, filepath.Join(frameDir, "stdout"), &hdidleStdoutLength)
 }
 
 func collectPowerState(frameDir string) error {
-	type Device struct {
-		Id string `json:"id"`
-		Up bool   `json:"up"`
-	}
-	type DevicesResponse struct {
-		Devices []Device `json:"devices"`
-	}
-
-	client, err := openClient()
-	if err != nil {
-		panic(err)
-	}
-	resp, err := client.Get("http://unix/devices")
+	power, err := powerProvider.power()
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	metrics.setPower(power)
 
-	var responseBody DevicesResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseBody)
-	if err != nil {
-		return err
+	var disks []string
+	for disk := range power {
+		disks = append(disks, disk)
 	}
+	sort.Strings(disks)
 
 	var content = ""
-	sort.Slice(responseBody.Devices, func(i, j int) bool {
-		return responseBody.Devices[i].Id < responseBody.Devices[j].Id
-	})
-	for i := range responseBody.Devices {
-		upBool := responseBody.Devices[i].Up
+	for _, disk := range disks {
 		state := "down"
-		if upBool {
+		if power[disk] {
 			state = "up"
 		}
-		content += fmt.Sprintf("%s: %s\n",
-			responseBody.Devices[i].Id, state)
+		content += fmt.Sprintf("%s: %s\n", disk, state)
 	}
-
-	return os.WriteFile(filepath.Join(frameDir, "power"), []byte(content), 0644)
-}
-
-func openClient() (http.Client, error) {
-	conn, err := net.Dial("unix", "/tmp/spd.sock")
+	err = os.WriteFile(filepath.Join(frameDir, "power"), []byte(content), 0644)
 	if err != nil {
-		return http.Client{}, err
+		return err
 	}
 
-	c := http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return conn, nil
-			},
-		},
+	source, ok := powerProvider.(wattsSource)
+	if !ok {
+		return nil
+	}
+	watts := source.watts()
+	if len(watts) == 0 {
+		return nil
 	}
-	return c, err
+	content = ""
+	for _, disk := range disks {
+		if value, ok := watts[disk]; ok {
+			content += fmt.Sprintf("%s: %s\n", disk, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return os.WriteFile(filepath.Join(frameDir, "watts"), []byte(content), 0644)
 }
-func collectLog(dataDir, originLogPath, destLogPath string, logLen *int) error {
+
+// collectLog writes the lines added to the log since the previous frame.
+// The first pass only counts the lines already there, so a session holds
+// what was logged while recording. A log shorter than before was rotated and
+// is read from the start.
+func collectLog(originLogPath, destLogPath string, logLen *int) error {
 	file, err := os.Open(originLogPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	var lines []string
 	scanner := bufio.NewScanner(file)
-
-	if *logLen == 0 {
-		lineCount := 0
-		for scanner.Scan() {
-			lineCount++
-		}
-		*logLen = lineCount
-		return os.WriteFile(destLogPath, []byte{}, 0644)
-	}
-
-	var hdLog = ""
-	lineCount := 0
 	for scanner.Scan() {
-		lineCount++
-		if lineCount > *logLen {
-			line := scanner.Text()
-			disk := getDisk(line)
-			if err = handleDiskMapping(dataDir, disk); err != nil {
-				return err
-			}
-
-			hdLog += line + "\n"
-		}
+		lines = append(lines, scanner.Text())
 	}
-
 	if err := scanner.Err(); err != nil {
 		return err
 	}
 
-	return os.WriteFile(destLogPath, []byte(hdLog), 0644)
-}
-
-func handleDiskMapping(dataDir, disk string) error {
-	if !strings.HasPrefix(disk, "/") {
-		return nil
-	}
-
-	diskMappingFile := filepath.Join(dataDir, diskMappingFileName)
-	if data, err := os.ReadFile(diskMappingFile); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, disk) {
-				return nil
-			}
-		}
-	}
-
-	s, err := os.Readlink(disk)
-	if err != nil {
-		return err
+	if *logLen == logNotRead {
+		*logLen = len(lines)
+		return os.WriteFile(destLogPath, []byte{}, 0644)
 	}
-
-	device := filepath.Base(s)
-
-	f, err := os.OpenFile(diskMappingFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if len(lines) < *logLen {
+		*logLen = 0
 	}
-	defer f.Close()
-
-	mappingLine := fmt.Sprintf("%s:%s", disk, device)
-	if _, err = f.WriteString(mappingLine + "\n"); err != nil {
-		return err
+	var hdLog = ""
+	for _, line := range lines[*logLen:] {
+		hdLog += line + "\n"
 	}
-	return nil
-}
+	*logLen = len(lines)
 
-func getDisk(line string) string {
-	dataEntries := strings.Split(line, ",")
-	for i := range dataEntries {
-		dataPair := strings.Split(dataEntries[i], ":")
-		if dataPair[0] == "disk" {
-			return strings.TrimSpace(dataPair[1])
-		}
-	}
-	return ""
+	return os.WriteFile(destLogPath, []byte(hdLog), 0644)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectLogClaimsEachLineOnce(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		initial string
+	}{
+		{"log with earlier lines", "sdb spindown\n"},
+		{"empty log", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			origin := filepath.Join(dir, "hd-idle.out")
+			if err := os.WriteFile(origin, []byte(test.initial), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			logLen := logNotRead
+			growth := []string{"", "sda spindown\n", "sda spinup\n", ""}
+			var frames []sessionFrame
+			start := time.Unix(1000, 0)
+			for i, lines := range growth {
+				if err := appendFile(origin, lines); err != nil {
+					t.Fatal(err)
+				}
+				dest := filepath.Join(dir, "stdout")
+				if err := collectLog(origin, dest, &logLen); err != nil {
+					t.Fatal(err)
+				}
+				content, err := os.ReadFile(dest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 && string(content) != lines {
+					t.Errorf("frame %d: got %q, want %q", i, content, lines)
+				}
+				frames = append(frames, sessionFrame{Id: string(rune('a' + i)), Time: start.Add(time.Duration(i) * frameInterval), Stdout: string(content)})
+			}
+
+			claims := hdIdleClaims(frames, nil)
+			if len(claims) != 2 {
+				t.Fatalf("got %d claims, want 2: %+v", len(claims), claims)
+			}
+			if claims[0].Kind != "down" || claims[0].Frame != "b" || claims[1].Kind != "up" || claims[1].Frame != "c" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestCollectLogRotated(t *testing.T) {
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "hd-idle.log")
+	dest := filepath.Join(dir, "log")
+	if err := os.WriteFile(origin, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logLen := logNotRead
+	if err := collectLog(origin, dest, &logLen); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(origin, []byte("three\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := collectLog(origin, dest, &logLen); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(dest); string(content) != "three\n" {
+		t.Errorf("got %q after rotation", content)
+	}
+}
+
+func appendFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(content)
+	return err
+}
+
+func TestStartRecordingWhileRecording(t *testing.T) {
+	recordingMutex.Lock()
+	currentSessionDir = filepath.Join(t.TempDir(), "nightly;1000")
+	recordingMutex.Unlock()
+	defer func() {
+		recordingMutex.Lock()
+		currentSessionDir = ""
+		recordingMutex.Unlock()
+	}()
+
+	dataDir := t.TempDir()
+	if _, err := startRecording(dataDir, "01", recordOptions{}); !errors.Is(err, errRecording) {
+		t.Errorf("got %v, want %v", err, errRecording)
+	}
+	if session := recordingSession(); session != "nightly;1000" {
+		t.Errorf("recording '%s' after the refused start", session)
+	}
+	if entries, _ := os.ReadDir(dataDir); len(entries) != 0 {
+		t.Errorf("the refused start wrote %d entries", len(entries))
+	}
+	if isCollecting() {
+		t.Errorf("the refused start collects frames")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const markersFileName = "markers.json"
+
+// marker is an operation run by a scenario, e.g. a workload step, so the
+// activity recorded in the frames can be traced back to it.
+type marker struct {
+	Label     string `json:"label"`
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Detail    string `json:"detail"`
+	// Frame is the time of the recording when the marker was received, it
+	// differs from Start and End on a virtual clock
+	Frame string `json:"frame"`
+}
+
+var markersMutex sync.Mutex
+
+func addMarker(sessionDir string, m marker) error {
+	markersMutex.Lock()
+	defer markersMutex.Unlock()
+
+	markers, err := sessionMarkers(sessionDir)
+	if err != nil {
+		return err
+	}
+	m.Frame = clockNow().Format(time.RFC3339)
+	content, err := json.Marshal(append(markers, m))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionDir, markersFileName), content, 0644)
+}
+
+func sessionMarkers(sessionDir string) ([]marker, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, markersFileName))
+	if os.IsNotExist(err) {
+		return []marker{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var markers []marker
+	err = json.Unmarshal(content, &markers)
+	return markers, err
+}
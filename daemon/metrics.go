@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frameSecondsBuckets are the upper bounds of the frame write latency
+// histogram.
+var frameSecondsBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// daemonMetrics are exposed in the Prometheus text format on GET /metrics.
+// The diskstats and the power state are the ones collected last, so
+// scraping doesn't move the synthetic sources forward.
+type daemonMetrics struct {
+	mutex           sync.Mutex
+	frames          uint64
+	frameBuckets    []uint64
+	frameSecondsSum float64
+	collectorErrors map[string]uint64
+	diskstats       map[string]diskStat
+	power           map[string]bool
+	hdIdleEvents    map[string]map[string]uint64
+	hdIdleLogLines  uint64
+}
+
+var metrics = daemonMetrics{
+	frameBuckets:    make([]uint64, len(frameSecondsBuckets)),
+	collectorErrors: make(map[string]uint64),
+	hdIdleEvents:    map[string]map[string]uint64{"spindown": {}, "spinup": {}},
+}
+
+func (m *daemonMetrics) observeFrame(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.frames++
+	m.frameSecondsSum += d.Seconds()
+	for i, bound := range frameSecondsBuckets {
+		if d.Seconds() <= bound {
+			m.frameBuckets[i]++
+		}
+	}
+}
+
+// collectorFailed counts the error of the collector recording the session and
+// returns it.
+func collectorFailed(session, collector string, err error) error {
+	collectorStreak(session, collector, err)
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.collectorErrors[collector]++
+	return err
+}
+
+func (m *daemonMetrics) setDiskstats(stats map[string]diskStat) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.diskstats = stats
+}
+
+func (m *daemonMetrics) setPower(power map[string]bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.power = power
+}
+
+// countHdIdleEvents counts the spindown and spinup lines hd-idle wrote to
+// stdout and the lines of its log in the frame, which only holds the lines
+// added since the previous one.
+func countHdIdleEvents(frameDir string) {
+	stdout, _ := os.ReadFile(filepath.Join(frameDir, "stdout"))
+	hdIdleLog, _ := os.ReadFile(filepath.Join(frameDir, "log"))
+	claims := hdIdleClaims([]sessionFrame{{Stdout: string(stdout)}}, currentDiskMapping())
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	for _, claim := range claims {
+		metrics.hdIdleEvents["spin"+claim.Kind][claim.Disk]++
+	}
+	for _, line := range strings.Split(string(hdIdleLog), "\n") {
+		if strings.TrimSpace(line) != "" {
+			metrics.hdIdleLogLines++
+		}
+	}
+}
+
+func writeMetrics(w io.Writer) {
+	recording := recordingSession() != ""
+	flightRecorder := flightRecorderStatus().Running
+	replaying := replayingSession() != ""
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	writeMetricHeader(w, "hdtd_recording", "gauge", "Whether a session is being recorded.")
+	fmt.Fprintf(w, "hdtd_recording %d\n", boolMetric(recording))
+	writeMetricHeader(w, "hdtd_flight_recorder", "gauge", "Whether the flight recorder is running.")
+	fmt.Fprintf(w, "hdtd_flight_recorder %d\n", boolMetric(flightRecorder))
+	writeMetricHeader(w, "hdtd_replaying", "gauge", "Whether a session is being replayed.")
+	fmt.Fprintf(w, "hdtd_replaying %d\n", boolMetric(replaying))
+
+	writeMetricHeader(w, "hdtd_frame_write_seconds", "histogram", "Time taken to collect and write a frame.")
+	for i, bound := range frameSecondsBuckets {
+		fmt.Fprintf(w, "hdtd_frame_write_seconds_bucket{le=\"%g\"} %d\n", bound, metrics.frameBuckets[i])
+	}
+	fmt.Fprintf(w, "hdtd_frame_write_seconds_bucket{le=\"+Inf\"} %d\n", metrics.frames)
+	fmt.Fprintf(w, "hdtd_frame_write_seconds_sum %g\n", metrics.frameSecondsSum)
+	fmt.Fprintf(w, "hdtd_frame_write_seconds_count %d\n", metrics.frames)
+
+	writeMetricHeader(w, "hdtd_collector_errors_total", "counter", "Errors of the collectors, by collector.")
+	for _, collector := range sortedKeys(metrics.collectorErrors) {
+		fmt.Fprintf(w, "hdtd_collector_errors_total{collector=%q} %d\n", collector, metrics.collectorErrors[collector])
+	}
+
+	devices := sortedKeys(metrics.diskstats)
+	counters := []struct {
+		name, help string
+		value      func(diskStat) uint64
+	}{
+		{"hdtd_disk_reads_completed_total", "Reads completed, from diskstats.", func(s diskStat) uint64 { return s.ReadsCompleted }},
+		{"hdtd_disk_sectors_read_total", "Sectors read, from diskstats.", func(s diskStat) uint64 { return s.SectorsRead }},
+		{"hdtd_disk_writes_completed_total", "Writes completed, from diskstats.", func(s diskStat) uint64 { return s.WritesCompleted }},
+		{"hdtd_disk_sectors_written_total", "Sectors written, from diskstats.", func(s diskStat) uint64 { return s.SectorsWritten }},
+	}
+	for _, counter := range counters {
+		writeMetricHeader(w, counter.name, "counter", counter.help)
+		for _, device := range devices {
+			fmt.Fprintf(w, "%s{device=%q} %d\n", counter.name, device, counter.value(metrics.diskstats[device]))
+		}
+	}
+
+	writeMetricHeader(w, "hdtd_disk_power_up", "gauge", "Whether the disk is drawing power, as last seen by spd.")
+	for _, disk := range sortedKeys(metrics.power) {
+		fmt.Fprintf(w, "hdtd_disk_power_up{disk=%q} %d\n", disk, boolMetric(metrics.power[disk]))
+	}
+
+	writeMetricHeader(w, "hdtd_hdidle_events_total", "counter", "Spindown and spinup lines written by hd-idle, by disk.")
+	for _, event := range sortedKeys(metrics.hdIdleEvents) {
+		for _, disk := range sortedKeys(metrics.hdIdleEvents[event]) {
+			fmt.Fprintf(w, "hdtd_hdidle_events_total{disk=%q,event=%q} %d\n", disk, event, metrics.hdIdleEvents[event][disk])
+		}
+	}
+	writeMetricHeader(w, "hdtd_hdidle_log_lines_total", "counter", "Lines written to the hd-idle log.")
+	fmt.Fprintf(w, "hdtd_hdidle_log_lines_total %d\n", metrics.hdIdleLogLines)
+}
+
+func serveMetrics(c *gin.Context) {
+	var buffer bytes.Buffer
+	writeMetrics(&buffer)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buffer.Bytes())
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func boolMetric(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
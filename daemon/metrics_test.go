@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	metrics = daemonMetrics{
+		frameBuckets:    make([]uint64, len(frameSecondsBuckets)),
+		collectorErrors: make(map[string]uint64),
+		hdIdleEvents:    map[string]map[string]uint64{"spindown": {}, "spinup": {}},
+	}
+
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "hd-idle.out")
+	if err := os.WriteFile(origin, []byte("sdb spindown\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logLen := logNotRead
+	for i, lines := range []string{"", "sda spindown\n", "sda spinup\nsdb spinup\n", ""} {
+		if err := appendFile(origin, lines); err != nil {
+			t.Fatal(err)
+		}
+		frameDir := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.MkdirAll(frameDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := collectLog(origin, filepath.Join(frameDir, "stdout"), &logLen); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(frameDir, "log"), []byte(lines), 0644); err != nil {
+			t.Fatal(err)
+		}
+		countHdIdleEvents(frameDir)
+	}
+	_ = collectorFailed("", "log", os.ErrNotExist)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", serveMetrics)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %s", contentType)
+	}
+
+	samples := parseExposition(t, recorder.Body.String())
+	expected := map[string]float64{
+		`hdtd_hdidle_events_total{disk="sda",event="spindown"}`: 1,
+		`hdtd_hdidle_events_total{disk="sda",event="spinup"}`:   1,
+		`hdtd_hdidle_events_total{disk="sdb",event="spinup"}`:   1,
+		`hdtd_hdidle_log_lines_total`:                           3,
+		`hdtd_collector_errors_total{collector="log"}`:          1,
+		`hdtd_recording`: 0,
+	}
+	for sample, value := range expected {
+		got, ok := samples[sample]
+		if !ok {
+			t.Errorf("%s missing", sample)
+		} else if got != value {
+			t.Errorf("%s = %g, want %g", sample, got, value)
+		}
+	}
+	if _, ok := samples[`hdtd_hdidle_events_total{disk="sdb",event="spindown"}`]; ok {
+		t.Error("the spindown logged before the first frame was counted")
+	}
+}
+
+// parseExposition reads the samples of the Prometheus text format, checking
+// every metric is announced by its HELP and TYPE lines.
+func parseExposition(t *testing.T, body string) map[string]float64 {
+	samples := make(map[string]float64)
+	typed := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			typed[strings.Fields(line)[2]] = true
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("malformed line %q", line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("malformed value in %q", line)
+		}
+		name, _, _ := strings.Cut(line[:i], "{")
+		family := name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base, found := strings.CutSuffix(name, suffix); found && typed[base] {
+				family = base
+			}
+		}
+		if !typed[family] {
+			t.Errorf("%s has no TYPE line", name)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const (
+	mountInfoFile = "/proc/self/mountinfo"
+	sysDevBlock   = "/sys/dev/block"
+)
+
+type mount struct {
+	MajorMinor string `json:"major_minor"`
+	MountPoint string `json:"mount_point"`
+	FsType     string `json:"fs_type"`
+	Source     string `json:"source"`
+}
+
+func readMounts() ([]mount, error) {
+	file, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields, superFields, found := strings.Cut(scanner.Text(), " - ")
+		if !found {
+			continue
+		}
+		cols := strings.Fields(fields)
+		superCols := strings.Fields(superFields)
+		if len(cols) < 5 || len(superCols) < 2 {
+			continue
+		}
+		mounts = append(mounts, mount{
+			MajorMinor: cols[2],
+			MountPoint: unescapeMountPath(cols[4]),
+			FsType:     superCols[0],
+			Source:     superCols[1],
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath reverts the octal escaping the kernel applies to spaces,
+// tabs, new lines and backslashes in mountinfo paths.
+func unescapeMountPath(path string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(path)
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	notifiersFileName         = "notifiers.json"
+	defaultNotifyAttempts     = 5
+	defaultNotifyBackoff      = 10
+	defaultNotifyTimeout      = 10
+	maxNotifyBackoff          = 10 * time.Minute
+	collectorFailureThreshold = 3
+)
+
+// notificationEvents are what a notifier can subscribe to.
+var notificationEvents = []string{"recording_finished", "verdict", "anomaly", "collector_failing"}
+
+// notifier calls a webhook or runs a command on session events. The command
+// gets the notification on stdin, and the event and the session in the
+// HDTD_EVENT and HDTD_SESSION environment variables.
+type notifier struct {
+	Name    string   `json:"name"`
+	Webhook string   `json:"webhook,omitempty"`
+	Command []string `json:"command,omitempty"`
+	// Events are the events notified, all of them when empty
+	Events []string `json:"events"`
+	// Attempts is the number of deliveries tried before giving up, the delay
+	// between them starting at Backoff seconds and doubling every time
+	Attempts int   `json:"attempts"`
+	Backoff  int64 `json:"backoff"`
+	Timeout  int64 `json:"timeout"`
+}
+
+type notification struct {
+	Event    string `json:"event"`
+	Session  string `json:"session,omitempty"`
+	Time     string `json:"time"`
+	Message  string `json:"message"`
+	Evidence any    `json:"evidence,omitempty"`
+}
+
+type notifierStatus struct {
+	notifier
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+var (
+	notifiersMutex   sync.Mutex
+	notifiers        = make(map[string]*notifierStatus)
+	notifiersDataDir string
+)
+
+// loadNotifiers sets up the notifiers saved in the data directory.
+func loadNotifiers(dataDir string) {
+	notifiersMutex.Lock()
+	notifiersDataDir = dataDir
+	notifiersMutex.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(dataDir, notifiersFileName))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to read notifiers. %s", err)
+		return
+	}
+	var saved []notifier
+	if err = json.Unmarshal(content, &saved); err != nil {
+		log.Printf("Unable to parse %s. %s", notifiersFileName, err)
+		return
+	}
+	for _, n := range saved {
+		if err = addNotifier(n); err != nil {
+			log.Printf("Notifier '%s' disabled. %s", n.Name, err)
+		}
+	}
+}
+
+// addNotifier sets up the notifier, replacing the one with the same name.
+func addNotifier(n notifier) error {
+	if n.Name == "" {
+		return fmt.Errorf("the notifier needs a name")
+	}
+	if (n.Webhook == "") == (len(n.Command) == 0) {
+		return fmt.Errorf("the notifier needs either a webhook or a command")
+	}
+	for _, event := range n.Events {
+		if !isNotificationEvent(event) {
+			return fmt.Errorf("unknown event '%s', expected one of %s", event, strings.Join(notificationEvents, ", "))
+		}
+	}
+	if n.Attempts <= 0 {
+		n.Attempts = defaultNotifyAttempts
+	}
+	if n.Backoff <= 0 {
+		n.Backoff = defaultNotifyBackoff
+	}
+	if n.Timeout <= 0 {
+		n.Timeout = defaultNotifyTimeout
+	}
+
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	notifiers[n.Name] = &notifierStatus{notifier: n}
+	return saveNotifiers()
+}
+
+func removeNotifier(name string) (bool, error) {
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	if _, ok := notifiers[name]; !ok {
+		return false, nil
+	}
+	delete(notifiers, name)
+	return true, saveNotifiers()
+}
+
+func listNotifiers() []notifierStatus {
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	statuses := []notifierStatus{}
+	for _, status := range notifiers {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// saveNotifiers keeps the notifiers across restarts. notifiersMutex must be
+// held.
+func saveNotifiers() error {
+	saved := []notifier{}
+	for _, status := range notifiers {
+		saved = append(saved, status.notifier)
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].Name < saved[j].Name
+	})
+	content, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(notifiersDataDir, notifiersFileName), content, 0644)
+}
+
+func (n notifier) wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// notifying tells whether a notifier wants the event, so the evidence is
+// only gathered when it is sent.
+func notifying(event string) bool {
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	for _, status := range notifiers {
+		if status.wants(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends the notification to every notifier wanting it, in the
+// background.
+func notify(event, session, message string, evidence any) {
+	n := notification{Event: event, Session: session, Time: clockNow().Format(time.RFC3339), Message: message, Evidence: evidence}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("Unable to encode the %s notification. %s", event, err)
+		return
+	}
+
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	for _, status := range notifiers {
+		if status.wants(event) {
+			go deliver(status.notifier, n, payload)
+		}
+	}
+}
+
+// deliver tries the notifier until it succeeds or runs out of attempts,
+// waiting longer and longer between them.
+func deliver(n notifier, event notification, payload []byte) {
+	backoff := time.Duration(n.Backoff) * time.Second
+	var err error
+	for attempt := 1; attempt <= n.Attempts; attempt++ {
+		if n.Webhook != "" {
+			err = postWebhook(n, payload)
+		} else {
+			err = runNotifyCommand(n, event, payload)
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("Notifier '%s' failed to send %s (attempt %d of %d). %s", n.Name, event.Event, attempt, n.Attempts, err)
+		if attempt < n.Attempts {
+			time.Sleep(backoff)
+			backoff = min(2*backoff, maxNotifyBackoff)
+		}
+	}
+
+	notifiersMutex.Lock()
+	defer notifiersMutex.Unlock()
+	status, ok := notifiers[n.Name]
+	if !ok {
+		return
+	}
+	if err != nil {
+		status.Failed++
+		status.LastError = err.Error()
+		return
+	}
+	status.Delivered++
+}
+
+func postWebhook(n notifier, payload []byte) error {
+	client := http.Client{Timeout: time.Duration(n.Timeout) * time.Second}
+	resp, err := client.Post(n.Webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func runNotifyCommand(n notifier, event notification, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.Command[0], n.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "HDTD_EVENT="+event.Event, "HDTD_SESSION="+event.Session)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// notifyRecordingFinished sends the statistics, the anomalies and the verdict
+// of the session.
+func notifyRecordingFinished(sessionDir string) {
+	if !notifying("recording_finished") {
+		return
+	}
+	session := filepath.Base(sessionDir)
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mapping, err := sessionDiskMapping(sessionDir)
+	if err != nil {
+		mapping = legacyDiskMapping(filepath.Dir(sessionDir))
+	}
+	idleTimes, err := sessionIdleTimes(sessionDir, mapping)
+	if err != nil {
+		log.Println(err)
+	}
+	v, err := sessionVerdict(sessionDir)
+	if err != nil {
+		log.Println(err)
+	}
+	anomalies := []sessionEvent{}
+	for _, event := range verifySession(frames, mapping, verifyWindow) {
+		if event.Type == "anomaly" {
+			anomalies = append(anomalies, event)
+		}
+	}
+
+	evidence := struct {
+		Frames     int              `json:"frames"`
+		Statistics []diskStatistics `json:"statistics"`
+		Anomalies  []sessionEvent   `json:"anomalies"`
+		Verdict    *verdict         `json:"verdict"`
+	}{len(frames), sessionStatistics(frames, idleTimes), anomalies, v}
+	notify("recording_finished", session,
+		fmt.Sprintf("recording finished after %d frames with %d anomalies", len(frames), len(anomalies)), evidence)
+}
+
+// notifyVerdict sends the verdict of a scenario with the analysis of the
+// session it ran in.
+func notifyVerdict(sessionDir string, v verdict) {
+	if !notifying("verdict") {
+		return
+	}
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		log.Println(err)
+	}
+	evidence := struct {
+		Verdict verdict        `json:"verdict"`
+		Disks   []diskAnalysis `json:"disks"`
+	}{v, analyzeSession(frames)}
+	notify("verdict", filepath.Base(sessionDir), strings.TrimSpace(v.Verdict+" "+v.Message), evidence)
+}
+
+// anomalyWatch keeps the last frames of the session being recorded, to spot
+// a disk waking up without I/O as soon as the frame after it is collected.
+var anomalyWatch struct {
+	sync.Mutex
+	session string
+	frames  []sessionFrame
+}
+
+// watchSpuriousSpinUps notifies a spurious spin-up, as counted by
+// analyzeSession: the disk comes up with no I/O in that frame nor in the
+// next one.
+func watchSpuriousSpinUps(sessionDir, frameDir string) {
+	if !notifying("anomaly") {
+		return
+	}
+	unix, err := strconv.ParseInt(filepath.Base(frameDir), 10, 64)
+	if err != nil {
+		return
+	}
+	frame, err := loadSessionFrame(frameDir, time.Unix(unix, 0))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	anomalyWatch.Lock()
+	defer anomalyWatch.Unlock()
+	session := filepath.Base(sessionDir)
+	if anomalyWatch.session != session {
+		anomalyWatch.session = session
+		anomalyWatch.frames = nil
+	}
+	anomalyWatch.frames = append(anomalyWatch.frames, frame)
+	if len(anomalyWatch.frames) > 3 {
+		anomalyWatch.frames = anomalyWatch.frames[1:]
+	}
+	frames := anomalyWatch.frames
+	if len(frames) < 3 {
+		return
+	}
+
+	for _, disk := range sortedKeys(frames[1].Power) {
+		if frames[0].Power[disk] != "down" || frames[1].Power[disk] != "up" ||
+			diskActiveInFrame(frames, 1, disk) || diskActiveInFrame(frames, 2, disk) {
+			continue
+		}
+		type frameEvidence struct {
+			Frame     string   `json:"frame"`
+			Power     string   `json:"power"`
+			Diskstats diskStat `json:"diskstats"`
+		}
+		var evidence []frameEvidence
+		for _, f := range frames {
+			evidence = append(evidence, frameEvidence{Frame: f.Id, Power: f.Power[disk], Diskstats: f.Diskstats[disk]})
+		}
+		notify("anomaly", session, fmt.Sprintf("%s woke up without I/O in frame %s", disk, frames[1].Id), struct {
+			Disk   string          `json:"disk"`
+			Frames []frameEvidence `json:"frames"`
+		}{disk, evidence})
+	}
+}
+
+// collectorStreaks counts, for each collector, the frames in a row where it
+// failed. collectFrame stops at the first collector failing, so the ones after it
+// don't run in that frame and their streak starts over: when two collectors
+// keep failing, only the first one is notified.
+var collectorStreaks = struct {
+	sync.Mutex
+	failures map[string]int
+	frame    map[string]bool
+}{failures: make(map[string]int), frame: make(map[string]bool)}
+
+// collectorStreak counts the failure of the collector in the current frame,
+// and notifies it once it failed collectorFailureThreshold frames in a row.
+func collectorStreak(session, collector string, err error) {
+	collectorStreaks.Lock()
+	defer collectorStreaks.Unlock()
+	collectorStreaks.frame[collector] = true
+	collectorStreaks.failures[collector]++
+	if collectorStreaks.failures[collector] != collectorFailureThreshold {
+		return
+	}
+	notify("collector_failing", session,
+		fmt.Sprintf("collector %s failed %d frames in a row", collector, collectorFailureThreshold),
+		struct {
+			Collector string `json:"collector"`
+			Failures  int    `json:"failures"`
+			Error     string `json:"error"`
+		}{collector, collectorFailureThreshold, err.Error()})
+}
+
+// endCollectorStreaks resets the streaks of the collectors that didn't fail
+// in the frame.
+func endCollectorStreaks() {
+	collectorStreaks.Lock()
+	defer collectorStreaks.Unlock()
+	for collector := range collectorStreaks.failures {
+		if !collectorStreaks.frame[collector] {
+			delete(collectorStreaks.failures, collector)
+		}
+	}
+	clear(collectorStreaks.frame)
+}
+
+func isNotificationEvent(event string) bool {
+	for _, e := range notificationEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
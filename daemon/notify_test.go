@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook answers the given statuses in turn, then 200, and keeps the
+// requests.
+type webhook struct {
+	mutex    sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.bodies = append(w.bodies, body)
+	w.times = append(w.times, time.Now())
+	status := http.StatusOK
+	if len(w.statuses) > 0 {
+		status, w.statuses = w.statuses[0], w.statuses[1:]
+	}
+	rw.WriteHeader(status)
+}
+
+func (w *webhook) requests() ([][]byte, []time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([][]byte{}, w.bodies...), append([]time.Time{}, w.times...)
+}
+
+func setupNotifiers(t *testing.T) {
+	t.Helper()
+	notifiersMutex.Lock()
+	notifiers = make(map[string]*notifierStatus)
+	notifiersDataDir = t.TempDir()
+	notifiersMutex.Unlock()
+}
+
+func waitForDeliveries(t *testing.T, deliveries int) []notifierStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		statuses := listNotifiers()
+		done := 0
+		for _, status := range statuses {
+			done += status.Delivered + status.Failed
+		}
+		if done >= deliveries {
+			return statuses
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("the notifications weren't delivered: %+v", listNotifiers())
+	return nil
+}
+
+func TestNotifyWebhookRetries(t *testing.T) {
+	setupNotifiers(t)
+	flaky := &webhook{statuses: []int{http.StatusServiceUnavailable}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	down := &webhook{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+
+	for _, n := range []notifier{
+		{Name: "flaky", Webhook: flakyServer.URL, Attempts: 3, Backoff: 1},
+		{Name: "down", Webhook: downServer.URL, Attempts: 2, Backoff: 1, Events: []string{"verdict"}},
+		{Name: "other", Webhook: downServer.URL, Events: []string{"anomaly"}},
+	} {
+		if err := addNotifier(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved, err := os.ReadFile(filepath.Join(notifiersDataDir, notifiersFileName))
+	if err != nil || !strings.Contains(string(saved), `"name":"flaky"`) {
+		t.Errorf("notifiers not saved: %s %v", saved, err)
+	}
+
+	notify("verdict", "01;1767535444", "fail spun up", map[string]string{"disk": "sda"})
+	statuses := waitForDeliveries(t, 2)
+
+	for _, status := range statuses {
+		switch status.Name {
+		case "flaky":
+			if status.Delivered != 1 || status.Failed != 0 {
+				t.Errorf("flaky: got %d delivered and %d failed", status.Delivered, status.Failed)
+			}
+		case "down":
+			if status.Delivered != 0 || status.Failed != 1 || !strings.Contains(status.LastError, "500") {
+				t.Errorf("down: got %d delivered, %d failed, last error '%s'", status.Delivered, status.Failed, status.LastError)
+			}
+		case "other":
+			if status.Delivered != 0 || status.Failed != 0 {
+				t.Errorf("other got a verdict notification")
+			}
+		}
+	}
+
+	bodies, times := flaky.requests()
+	if len(bodies) != 2 {
+		t.Fatalf("flaky: got %d requests, want 2", len(bodies))
+	}
+	if gap := times[1].Sub(times[0]); gap < time.Second {
+		t.Errorf("retried after %s, before the backoff", gap)
+	}
+	if string(bodies[0]) != string(bodies[1]) {
+		t.Errorf("the retry sent another payload")
+	}
+	var payload struct {
+		Event    string            `json:"event"`
+		Session  string            `json:"session"`
+		Time     string            `json:"time"`
+		Message  string            `json:"message"`
+		Evidence map[string]string `json:"evidence"`
+	}
+	if err = json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "verdict" || payload.Session != "01;1767535444" || payload.Message != "fail spun up" ||
+		payload.Evidence["disk"] != "sda" {
+		t.Errorf("unexpected payload %s", bodies[0])
+	}
+	if _, err = time.Parse(time.RFC3339, payload.Time); err != nil {
+		t.Errorf("time: %s", err)
+	}
+	if bodies, _ = down.requests(); len(bodies) != 2 {
+		t.Errorf("down: got %d requests, want 2", len(bodies))
+	}
+}
+
+func TestNotifyRecordingFinished(t *testing.T) {
+	setupNotifiers(t)
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	if err := addNotifier(notifier{Name: "hook", Webhook: server.URL, Events: []string{"recording_finished"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := recordFrames(t,
+		[]string{"", "sda spindown\n", "", "", "", "", "", "", "", "", ""},
+		[]string{"up", "up", "up", "up", "up", "up", "up", "up", "up", "up", "up"})
+	sessionDir := filepath.Join(t.TempDir(), "01;1000")
+	for _, frame := range frames {
+		frameDir := filepath.Join(sessionDir, frame.Id)
+		if err := os.MkdirAll(frameDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{
+			"diskstats": "   8       0 sda 10 0 100 0 20 0 200 0 0 0 0 0 0 0 0 0 0\n",
+			"power":     "sda: " + frame.Power["sda"] + "\n",
+			"stdout":    frame.Stdout,
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(frameDir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	notifyRecordingFinished(sessionDir)
+	waitForDeliveries(t, 1)
+	bodies, _ := hook.requests()
+	var payload struct {
+		Event    string `json:"event"`
+		Session  string `json:"session"`
+		Evidence struct {
+			Frames    int            `json:"frames"`
+			Anomalies []sessionEvent `json:"anomalies"`
+		} `json:"evidence"`
+	}
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "recording_finished" || payload.Session != "01;1000" || payload.Evidence.Frames != len(frames) {
+		t.Errorf("unexpected payload %s", bodies[0])
+	}
+	if len(payload.Evidence.Anomalies) != 1 {
+		t.Errorf("got %d anomalies, want the spindown claimed once", len(payload.Evidence.Anomalies))
+	}
+}
+
+func TestNotifyCollectorFailing(t *testing.T) {
+	setupNotifiers(t)
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	if err := addNotifier(notifier{Name: "hook", Webhook: server.URL, Events: []string{"collector_failing"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// recordingMutex is held by a recording starting or stopping while the
+	// virtual clock collects a frame, the collectors must not wait for it
+	recordingMutex.Lock()
+	for i := 0; i < collectorFailureThreshold; i++ {
+		_ = collectorFailed("01;1000", "power", os.ErrNotExist)
+		endCollectorStreaks()
+	}
+	recordingMutex.Unlock()
+
+	waitForDeliveries(t, 1)
+	bodies, _ := hook.requests()
+	var payload struct {
+		Event    string `json:"event"`
+		Session  string `json:"session"`
+		Evidence struct {
+			Collector string `json:"collector"`
+		} `json:"evidence"`
+	}
+	if err := json.Unmarshal(bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "collector_failing" || payload.Session != "01;1000" || payload.Evidence.Collector != "power" {
+		t.Errorf("unexpected payload %s", bodies[0])
+	}
+}
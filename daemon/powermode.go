@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adelolmo/hd-idle-test-daemon/powermode"
+)
+
+// openDrive opens the drives asked for their power mode, replaced by
+// powermode.Fake devices in tests.
+var openDrive = powermode.Open
+
+// probeDrive asks the drive of the disk for its power mode.
+func probeDrive(disk string) (powermode.Mode, error) {
+	path := filepath.Join("/dev", disk)
+	device, err := openDrive(path)
+	if err != nil {
+		return powermode.Unknown, err
+	}
+	defer device.Close()
+
+	mode, err := device.PowerMode()
+	if err != nil {
+		return powermode.Unknown, fmt.Errorf("%s: %w", path, err)
+	}
+	return mode, nil
+}
+
+// collectPowerMode stores the power mode reported by each drive, which is
+// available even when no smart plug is attached.
+func collectPowerMode(frameDir string, disks []string) error {
+	if len(disks) == 0 {
+		var err error
+		disks, err = physicalDisks()
+		if err != nil {
+			return err
+		}
+	}
+
+	var content = ""
+	for _, disk := range disks {
+		mode, err := probeDrive(disk)
+		if err != nil {
+			content += fmt.Sprintf("%s: %s (%s)\n", disk, mode, err)
+			continue
+		}
+		content += fmt.Sprintf("%s: %s\n", disk, mode)
+	}
+
+	return os.WriteFile(filepath.Join(frameDir, "powermode"), []byte(content), 0644)
+}
+
+// drivePower takes the power state from the drives themselves, for rigs
+// without smart plugs. A drive is down when it reports standby.
+type drivePower struct{}
+
+func (d drivePower) restart() {}
+
+func (d drivePower) power() (map[string]bool, error) {
+	disks, err := physicalDisks()
+	if err != nil {
+		return nil, err
+	}
+	power := make(map[string]bool)
+	for _, disk := range disks {
+		mode, err := probeDrive(disk)
+		if err != nil {
+			continue
+		}
+		power[disk] = mode != powermode.Standby
+	}
+	return power, nil
+}
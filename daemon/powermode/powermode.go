@@ -0,0 +1,48 @@
+// Package powermode asks a drive for its power mode without waking it up,
+// with ATA CHECK POWER MODE and, for SAS disks and USB bridges without ATA
+// pass-through, SCSI REQUEST SENSE.
+package powermode
+
+type Mode int
+
+const (
+	Unknown Mode = iota
+	Active
+	Idle
+	Standby
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Active:
+		return "active"
+	case Idle:
+		return "idle"
+	case Standby:
+		return "standby"
+	}
+	return "unknown"
+}
+
+// Device is a drive that reports its power mode.
+type Device interface {
+	PowerMode() (Mode, error)
+	Close() error
+}
+
+// Fake is a Device reporting the mode it is set to, to test without drives.
+type Fake struct {
+	Mode Mode
+	Err  error
+}
+
+func (f *Fake) PowerMode() (Mode, error) {
+	if f.Err != nil {
+		return Unknown, f.Err
+	}
+	return f.Mode, nil
+}
+
+func (f *Fake) Close() error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package powermode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	sgIO           = 0x2285
+	sgInterfaceId  = 'S'
+	sgDxferNone    = -1
+	sgDxferFromDev = -3
+	sgTimeoutMs    = 5000
+
+	ata16                = 0x85
+	ataProtocolNonData   = 3 << 1
+	ataCheckCondition    = 0x20
+	ataCheckPowerMode    = 0xe5
+	scsiRequestSense     = 0x03
+	senseLength          = 32
+	requestSenseLength   = 18
+	senseKeyIllegal      = 0x05
+	ascLowPowerCondition = 0x5e
+)
+
+var errNoAtaPassThrough = errors.New("no ATA pass-through")
+
+// sgIOHdr is struct sg_io_hdr from <scsi/sg.h>.
+type sgIOHdr struct {
+	InterfaceId    int32
+	DxferDirection int32
+	CmdLen         uint8
+	MxSbLen        uint8
+	IovecCount     uint16
+	DxferLen       uint32
+	Dxferp         unsafe.Pointer
+	Cmdp           unsafe.Pointer
+	Sbp            unsafe.Pointer
+	Timeout        uint32
+	Flags          uint32
+	PackId         int32
+	UsrPtr         unsafe.Pointer
+	Status         uint8
+	MaskedStatus   uint8
+	MsgStatus      uint8
+	SbLenWr        uint8
+	HostStatus     uint16
+	DriverStatus   uint16
+	Resid          int32
+	Duration       uint32
+	Info           uint32
+}
+
+// sgDevice sends the commands with the SG_IO ioctl, which works on the sd
+// devices as well as on the sg ones.
+type sgDevice struct {
+	file *os.File
+	// scsi is set once the device rejected ATA pass-through
+	scsi bool
+}
+
+// Open opens the block device, e.g. /dev/sda. Opening it doesn't wake the
+// drive up.
+func Open(path string) (Device, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &sgDevice{file: file}, nil
+}
+
+func (d *sgDevice) Close() error {
+	return d.file.Close()
+}
+
+func (d *sgDevice) PowerMode() (Mode, error) {
+	if !d.scsi {
+		mode, err := d.checkPowerMode()
+		if !errors.Is(err, errNoAtaPassThrough) {
+			return mode, err
+		}
+		d.scsi = true
+	}
+	return d.requestSense()
+}
+
+// checkPowerMode sends ATA CHECK POWER MODE in an ATA PASS-THROUGH(16) with
+// CK_COND set, so the count register holding the mode comes back in the
+// sense data.
+func (d *sgDevice) checkPowerMode() (Mode, error) {
+	cdb := []byte{ata16, ataProtocolNonData, ataCheckCondition, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, ataCheckPowerMode, 0}
+	sense := make([]byte, senseLength)
+	if err := d.send(cdb, nil, sense); err != nil {
+		return Unknown, err
+	}
+	return checkPowerModeSense(sense)
+}
+
+// checkPowerModeSense reads the power mode from the count register returned
+// in the sense data of CHECK POWER MODE.
+func checkPowerModeSense(sense []byte) (Mode, error) {
+	var count byte
+	switch sense[0] & 0x7f {
+	case 0x72:
+		if sense[1]&0x0f == senseKeyIllegal {
+			return Unknown, errNoAtaPassThrough
+		}
+		descriptor := findDescriptor(sense, 0x09)
+		if descriptor == nil {
+			return Unknown, errNoAtaPassThrough
+		}
+		count = descriptor[5]
+	case 0x70:
+		if sense[2]&0x0f == senseKeyIllegal {
+			return Unknown, errNoAtaPassThrough
+		}
+		count = sense[6]
+	default:
+		return Unknown, errNoAtaPassThrough
+	}
+
+	switch {
+	case count == 0x00 || count == 0x01:
+		return Standby, nil
+	case count >= 0x80 && count <= 0x83:
+		return Idle, nil
+	case count == 0xff || count == 0x40 || count == 0x41:
+		return Active, nil
+	}
+	return Unknown, fmt.Errorf("unexpected power mode 0x%02x", count)
+}
+
+// findDescriptor looks for a descriptor of the given type in descriptor
+// format sense data.
+func findDescriptor(sense []byte, descriptorType byte) []byte {
+	end := min(8+int(sense[7]), len(sense))
+	for i := 8; i+1 < end; i += 2 + int(sense[i+1]) {
+		if sense[i] == descriptorType && i+2+int(sense[i+1]) <= end {
+			return sense[i : i+2+int(sense[i+1])]
+		}
+	}
+	return nil
+}
+
+// requestSense asks for the sense data, which reports a low power condition
+// when the drive is idle or in standby. REQUEST SENSE doesn't change the
+// power condition.
+func (d *sgDevice) requestSense() (Mode, error) {
+	cdb := []byte{scsiRequestSense, 0, 0, 0, requestSenseLength, 0}
+	data := make([]byte, requestSenseLength)
+	if err := d.send(cdb, data, make([]byte, senseLength)); err != nil {
+		return Unknown, err
+	}
+	return requestSenseData(data)
+}
+
+// requestSenseData reads the power condition from the LOW POWER CONDITION ON
+// additional sense code qualifier: idle, idle_b and idle_c are idle, standby_z
+// and standby_y are standby.
+func requestSenseData(data []byte) (Mode, error) {
+	if data[0]&0x7f != 0x70 && data[0]&0x7f != 0x71 {
+		return Unknown, fmt.Errorf("unexpected sense data format 0x%02x", data[0])
+	}
+	if data[12] != ascLowPowerCondition {
+		return Active, nil
+	}
+	switch data[13] {
+	case 0x01, 0x03, 0x05, 0x06, 0x07, 0x08:
+		return Idle, nil
+	case 0x02, 0x04, 0x09, 0x0a:
+		return Standby, nil
+	}
+	return Idle, nil
+}
+
+func (d *sgDevice) send(cdb, data, sense []byte) error {
+	hdr := sgIOHdr{
+		InterfaceId:    sgInterfaceId,
+		DxferDirection: sgDxferNone,
+		CmdLen:         uint8(len(cdb)),
+		MxSbLen:        uint8(len(sense)),
+		Cmdp:           unsafe.Pointer(&cdb[0]),
+		Sbp:            unsafe.Pointer(&sense[0]),
+		Timeout:        sgTimeoutMs,
+	}
+	if len(data) > 0 {
+		hdr.DxferDirection = sgDxferFromDev
+		hdr.DxferLen = uint32(len(data))
+		hdr.Dxferp = unsafe.Pointer(&data[0])
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.file.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+	runtime.KeepAlive(cdb)
+	runtime.KeepAlive(data)
+	runtime.KeepAlive(sense)
+	if errno != 0 {
+		return errno
+	}
+	if hdr.HostStatus != 0 || (hdr.DriverStatus&0x0f != 0 && hdr.SbLenWr == 0) {
+		return fmt.Errorf("SG_IO failed, host status 0x%x, driver status 0x%x", hdr.HostStatus, hdr.DriverStatus)
+	}
+	return nil
+}
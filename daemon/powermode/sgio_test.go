@@ -0,0 +1,104 @@
+package powermode
+
+import (
+	"errors"
+	"testing"
+)
+
+// descriptorSense is descriptor format sense data with an ATA Status Return
+// descriptor holding the count register.
+func descriptorSense(senseKey, count byte) []byte {
+	sense := make([]byte, senseLength)
+	sense[0] = 0x72
+	sense[1] = senseKey
+	sense[7] = 14
+	sense[8] = 0x09
+	sense[9] = 12
+	sense[13] = count
+	return sense
+}
+
+func fixedSense(senseKey, count byte) []byte {
+	sense := make([]byte, senseLength)
+	sense[0] = 0x70
+	sense[2] = senseKey
+	sense[6] = count
+	return sense
+}
+
+func TestCheckPowerModeSense(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		sense []byte
+		mode  Mode
+		err   error
+	}{
+		{"descriptor standby", descriptorSense(0x01, 0x00), Standby, nil},
+		{"descriptor standby_y", descriptorSense(0x01, 0x01), Standby, nil},
+		{"descriptor idle", descriptorSense(0x01, 0x80), Idle, nil},
+		{"descriptor idle_c", descriptorSense(0x01, 0x83), Idle, nil},
+		{"descriptor active", descriptorSense(0x01, 0xff), Active, nil},
+		{"descriptor active_nv", descriptorSense(0x01, 0x40), Active, nil},
+		{"fixed standby", fixedSense(0x01, 0x00), Standby, nil},
+		{"fixed active", fixedSense(0x01, 0xff), Active, nil},
+		{"descriptor illegal request", descriptorSense(senseKeyIllegal, 0xff), Unknown, errNoAtaPassThrough},
+		{"fixed illegal request", fixedSense(senseKeyIllegal, 0xff), Unknown, errNoAtaPassThrough},
+		{"no sense", make([]byte, senseLength), Unknown, errNoAtaPassThrough},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mode, err := checkPowerModeSense(test.sense)
+			if mode != test.mode || !errors.Is(err, test.err) {
+				t.Errorf("got %s %v, want %s %v", mode, err, test.mode, test.err)
+			}
+		})
+	}
+
+	if _, err := checkPowerModeSense(descriptorSense(0x01, 0x10)); err == nil {
+		t.Error("an unexpected count register is accepted")
+	}
+}
+
+func TestRequestSenseData(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		asc, ascq byte
+		mode      Mode
+	}{
+		{"no low power condition", 0x00, 0x00, Active},
+		{"idle by timer", ascLowPowerCondition, 0x01, Idle},
+		{"standby_z by timer", ascLowPowerCondition, 0x02, Standby},
+		{"idle by command", ascLowPowerCondition, 0x03, Idle},
+		{"standby_z by command", ascLowPowerCondition, 0x04, Standby},
+		{"idle_b by timer", ascLowPowerCondition, 0x05, Idle},
+		{"idle_c by command", ascLowPowerCondition, 0x08, Idle},
+		{"standby_y by timer", ascLowPowerCondition, 0x09, Standby},
+		{"standby_y by command", ascLowPowerCondition, 0x0a, Standby},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := make([]byte, requestSenseLength)
+			data[0] = 0x70
+			data[12] = test.asc
+			data[13] = test.ascq
+			mode, err := requestSenseData(data)
+			if err != nil || mode != test.mode {
+				t.Errorf("got %s %v, want %s", mode, err, test.mode)
+			}
+		})
+	}
+
+	if _, err := requestSenseData(make([]byte, requestSenseLength)); err == nil {
+		t.Error("an unknown sense data format is accepted")
+	}
+}
+
+func TestFake(t *testing.T) {
+	var device Device = &Fake{Mode: Idle}
+	if mode, err := device.PowerMode(); mode != Idle || err != nil {
+		t.Errorf("got %s %v", mode, err)
+	}
+	failing := errors.New("no medium")
+	device = &Fake{Mode: Idle, Err: failing}
+	if mode, err := device.PowerMode(); mode != Unknown || !errors.Is(err, failing) {
+		t.Errorf("got %s %v", mode, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adelolmo/hd-idle-test-daemon/powermode"
+)
+
+func TestCollectPowerMode(t *testing.T) {
+	devices := map[string]powermode.Device{
+		"/dev/sda": &powermode.Fake{Mode: powermode.Standby},
+		"/dev/sdb": &powermode.Fake{Mode: powermode.Active},
+		"/dev/sdc": &powermode.Fake{Mode: powermode.Idle},
+		"/dev/sdd": &powermode.Fake{Err: errors.New("no medium")},
+	}
+	openDrive = func(path string) (powermode.Device, error) {
+		if device, ok := devices[path]; ok {
+			return device, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	defer func() {
+		openDrive = powermode.Open
+	}()
+
+	frameDir := t.TempDir()
+	if err := collectPowerMode(frameDir, []string{"sda", "sdb", "sdc", "sdd", "sde"}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(frameDir, "powermode"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sda: standby\n" +
+		"sdb: active\n" +
+		"sdc: idle\n" +
+		"sdd: unknown (/dev/sdd: no medium)\n" +
+		"sde: unknown (file does not exist)\n"
+	if string(content) != expected {
+		t.Errorf("got\n%s\nwant\n%s", content, expected)
+	}
+
+	modes := parsePower(string(content))
+	for disk, known := range map[string]bool{"sda": true, "sdb": true, "sdc": true, "sdd": false, "sde": false} {
+		if knownPowerState(modes[disk]) != known {
+			t.Errorf("%s: state '%s' known %t, want %t", disk, modes[disk], !known, known)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type processIO struct {
+	Pid        int
+	Comm       string
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+var (
+	previousProcessIO map[int]processIO
+	previousDiskstats map[string]diskStat
+)
+
+func resetProcIO() {
+	previousProcessIO = nil
+	previousDiskstats = nil
+}
+
+// collectProcIO samples the storage I/O counters of every process and writes
+// the per-process deltas in the frames where a watched disk shows activity.
+// It relies on the diskstats of the frame being already collected.
+func collectProcIO(frameDir string, disks []string) error {
+	diskstatsBytes, err := os.ReadFile(filepath.Join(frameDir, "diskstats"))
+	if err != nil {
+		return err
+	}
+	if len(disks) == 0 {
+		disks, err = physicalDisks()
+		if err != nil {
+			return err
+		}
+	}
+
+	stats := parseDiskstats(string(diskstatsBytes))
+	processes := sampleProcessIO()
+	defer func() {
+		previousDiskstats = stats
+		previousProcessIO = processes
+	}()
+
+	if previousDiskstats == nil {
+		return os.WriteFile(filepath.Join(frameDir, "procio"), []byte{}, 0644)
+	}
+
+	var content = ""
+	for _, disk := range disks {
+		current, ok := stats[disk]
+		if !ok || !current.activeSince(previousDiskstats[disk]) {
+			continue
+		}
+		previous := previousDiskstats[disk]
+		content += fmt.Sprintf("%s: %d sectors read, %d sectors written\n",
+			disk, current.SectorsRead-previous.SectorsRead, current.SectorsWritten-previous.SectorsWritten)
+	}
+	if content == "" {
+		return os.WriteFile(filepath.Join(frameDir, "procio"), []byte{}, 0644)
+	}
+
+	var deltas []processIO
+	for pid, current := range processes {
+		// a process started since the last sample did all its I/O in this frame
+		previous := previousProcessIO[pid]
+		if previous.Comm != current.Comm {
+			previous = processIO{}
+		}
+		delta := processIO{
+			Pid:        pid,
+			Comm:       current.Comm,
+			ReadBytes:  current.ReadBytes - min(previous.ReadBytes, current.ReadBytes),
+			WriteBytes: current.WriteBytes - min(previous.WriteBytes, current.WriteBytes),
+		}
+		if delta.ReadBytes == 0 && delta.WriteBytes == 0 {
+			continue
+		}
+		deltas = append(deltas, delta)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].ReadBytes+deltas[i].WriteBytes > deltas[j].ReadBytes+deltas[j].WriteBytes
+	})
+	for _, delta := range deltas {
+		content += fmt.Sprintf("%d %s: read %d B, written %d B\n",
+			delta.Pid, delta.Comm, delta.ReadBytes, delta.WriteBytes)
+	}
+
+	return os.WriteFile(filepath.Join(frameDir, "procio"), []byte(content), 0644)
+}
+
+func sampleProcessIO() map[int]processIO {
+	processes := make(map[int]processIO)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return processes
+	}
+
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// processes may exit while being sampled
+		file, err := os.Open(filepath.Join("/proc", e.Name(), "io"))
+		if err != nil {
+			continue
+		}
+		process := processIO{Pid: pid}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, value, found := strings.Cut(scanner.Text(), ":")
+			if !found {
+				continue
+			}
+			switch key {
+			case "read_bytes":
+				process.ReadBytes = parseCounter(strings.TrimSpace(value))
+			case "write_bytes":
+				process.WriteBytes = parseCounter(strings.TrimSpace(value))
+			}
+		}
+		file.Close()
+
+		comm, err := os.ReadFile(filepath.Join("/proc", e.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		process.Comm = strings.TrimSpace(string(comm))
+		processes[pid] = process
+	}
+	return processes
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth      = 960
+	chartMargin     = 50
+	chartIOHeight   = 140
+	chartPowerTop   = 150
+	chartPowerSize  = 16
+	chartHeight     = 200
+	chartTicks      = 6
+	reportTimestamp = "2006-01-02 15:04:05"
+)
+
+// sessionReport is everything shown in the HTML report of a session.
+type sessionReport struct {
+	Session    string
+	Generated  string
+	Start, End string
+	Verdict    *verdict
+	Stats      []diskStatistics
+	Charts     []reportChart
+	Events     []sessionEvent
+	Markers    []marker
+	// Environments are the snapshots taken at the start and at the end
+	Environments []reportEnvironment
+}
+
+type reportEnvironment struct {
+	Title       string
+	Environment *environment
+	fileName    string
+}
+
+// reportChart is the SVG chart of a disk: the sectors read and written in
+// every frame, the power state below them, and the hd-idle claims, markers
+// and anomalies over them.
+type reportChart struct {
+	Disk         string
+	MaxSectors   uint64
+	ReadPoints   string
+	WritePoints  string
+	PowerBands   []reportBand
+	Lines        []reportLine
+	Anomalies    []reportLine
+	Ticks        []reportLine
+	Width        int
+	Height       int
+	Left, Right  int
+	IOTop        int
+	IOBottom     int
+	PowerTop     int
+	PowerSize    int
+	PowerLabelY  int
+	TickLabelY   int
+	MarkerLabelY int
+}
+
+type reportBand struct {
+	X, Width float64
+	Color    string
+	Title    string
+}
+
+type reportLine struct {
+	X      float64
+	Color  string
+	Label  string
+	Dashed bool
+}
+
+// buildSessionReport gathers the frames, statistics, events, markers and
+// environment of the session.
+func buildSessionReport(dataDir, id string) (sessionReport, error) {
+	sessionDir := filepath.Join(dataDir, id)
+	report := sessionReport{Session: id, Generated: time.Now().Format(reportTimestamp)}
+
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		return report, err
+	}
+	if len(frames) == 0 {
+		return report, fmt.Errorf("no frames in session %s", id)
+	}
+	mapping, err := sessionDiskMapping(sessionDir)
+	if err != nil {
+		mapping = legacyDiskMapping(dataDir)
+	}
+	topology, err := sessionTopology(sessionDir)
+	if err != nil {
+		log.Println(err)
+	}
+	idleTimes, err := sessionIdleTimes(sessionDir, mapping)
+	if err != nil {
+		return report, err
+	}
+	if report.Verdict, err = sessionVerdict(sessionDir); err != nil {
+		return report, err
+	}
+	if report.Markers, err = sessionMarkers(sessionDir); err != nil {
+		return report, err
+	}
+	for _, snapshot := range []reportEnvironment{{Title: "Start", fileName: environmentStartFileName}, {Title: "End", fileName: environmentEndFileName}} {
+		if snapshot.Environment, err = sessionEnvironment(sessionDir, snapshot.fileName); err != nil {
+			return report, err
+		}
+		if snapshot.Environment != nil {
+			report.Environments = append(report.Environments, snapshot)
+		}
+	}
+
+	report.Start = frames[0].Time.Format(reportTimestamp)
+	report.End = frames[len(frames)-1].Time.Format(reportTimestamp)
+	report.Stats = sessionStatistics(frames, idleTimes)
+	report.Events = verifySession(frames, mapping, verifyWindow)
+
+	rows := sessionExportRows(frames, topology, mapping, nil)
+	var disks []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if !seen[row.Device] {
+			seen[row.Device] = true
+			disks = append(disks, row.Device)
+		}
+	}
+	for _, disk := range disks {
+		var diskRows []exportRow
+		for _, row := range rows {
+			if row.Device == disk {
+				diskRows = append(diskRows, row)
+			}
+		}
+		report.Charts = append(report.Charts, newReportChart(disk, diskRows, report.Markers,
+			frames[0].Time, frames[len(frames)-1].Time))
+	}
+	return report, nil
+}
+
+func newReportChart(disk string, rows []exportRow, markers []marker, start, end time.Time) reportChart {
+	chart := reportChart{
+		Disk:         disk,
+		Width:        chartWidth,
+		Height:       chartHeight,
+		Left:         chartMargin,
+		Right:        chartWidth - chartMargin,
+		IOTop:        10,
+		IOBottom:     chartIOHeight,
+		PowerTop:     chartPowerTop,
+		PowerSize:    chartPowerSize,
+		PowerLabelY:  chartPowerTop + chartPowerSize - 4,
+		TickLabelY:   chartHeight - 4,
+		MarkerLabelY: 20,
+	}
+	span := end.Sub(start)
+	x := func(t time.Time) float64 {
+		if span <= 0 {
+			return float64(chart.Left)
+		}
+		return float64(chart.Left) + float64(t.Sub(start))/float64(span)*float64(chart.Right-chart.Left)
+	}
+
+	for _, row := range rows {
+		chart.MaxSectors = max(chart.MaxSectors, row.SectorsReadDelta, row.SectorsWrittenDelta)
+	}
+	y := func(sectors uint64) float64 {
+		if chart.MaxSectors == 0 {
+			return float64(chart.IOBottom)
+		}
+		return float64(chart.IOBottom) - float64(sectors)/float64(chart.MaxSectors)*float64(chart.IOBottom-chart.IOTop)
+	}
+	var reads, writes []string
+	for _, row := range rows {
+		reads = append(reads, fmt.Sprintf("%.1f,%.1f", x(row.Time), y(row.SectorsReadDelta)))
+		writes = append(writes, fmt.Sprintf("%.1f,%.1f", x(row.Time), y(row.SectorsWrittenDelta)))
+	}
+	chart.ReadPoints = strings.Join(reads, " ")
+	chart.WritePoints = strings.Join(writes, " ")
+
+	// every frame's power state lasts until the next frame
+	for i, row := range rows {
+		state := row.Power
+		if state == "" {
+			state = row.DriveMode
+		}
+		if state == "" {
+			continue
+		}
+		bandEnd := end
+		if i+1 < len(rows) {
+			bandEnd = rows[i+1].Time
+		}
+		color := "#4caf50"
+		if state == "down" || state == "standby" {
+			color = "#9e9e9e"
+		}
+		last := len(chart.PowerBands) - 1
+		if last >= 0 && chart.PowerBands[last].Title == state {
+			chart.PowerBands[last].Width = x(bandEnd) - chart.PowerBands[last].X
+			continue
+		}
+		chart.PowerBands = append(chart.PowerBands, reportBand{X: x(row.Time), Width: x(bandEnd) - x(row.Time), Color: color, Title: state})
+	}
+
+	for _, row := range rows {
+		if row.SpinDownClaim {
+			chart.Lines = append(chart.Lines, reportLine{X: x(row.Time), Color: "#7b1fa2", Label: "hd-idle spindown"})
+		}
+		if row.SpinUpClaim {
+			chart.Lines = append(chart.Lines, reportLine{X: x(row.Time), Color: "#0288d1", Label: "hd-idle spinup"})
+		}
+		if row.Anomaly {
+			chart.Anomalies = append(chart.Anomalies, reportLine{X: x(row.Time), Color: "#d32f2f", Label: "anomaly"})
+		}
+	}
+	for _, m := range markers {
+		t, err := time.Parse(time.RFC3339, m.Frame)
+		if err != nil || t.Before(start) || t.After(end) {
+			continue
+		}
+		chart.Lines = append(chart.Lines, reportLine{X: x(t), Color: "#616161", Label: m.Label, Dashed: true})
+	}
+
+	for i := 0; i < chartTicks; i++ {
+		t := start.Add(span * time.Duration(i) / (chartTicks - 1))
+		chart.Ticks = append(chart.Ticks, reportLine{X: x(t), Label: t.Format("15:04:05")})
+	}
+	return chart
+}
+
+func writeSessionReport(w io.Writer, report sessionReport) error {
+	return reportTemplate.Execute(w, report)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"lower": strings.ToLower}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>hd-idle test session {{.Session}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #212121; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #bdbdbd; padding: 4px 8px; text-align: left; font-size: 0.9em; }
+th { background: #eeeeee; }
+pre { background: #f5f5f5; padding: 8px; overflow-x: auto; }
+svg { display: block; margin-bottom: 1.5em; }
+.anomaly { color: #d32f2f; }
+.legend span { margin-right: 1.5em; }
+</style>
+</head>
+<body>
+<h1>Session {{.Session}}</h1>
+<p>From {{.Start}} to {{.End}}. Report generated on {{.Generated}}.</p>
+{{with .Verdict}}<p>Verdict: <strong>{{.Verdict}}</strong> {{.Message}}</p>{{end}}
+
+<h2>Statistics</h2>
+<table>
+<tr><th>Disk</th><th>Spin-ups</th><th>Spin-downs</th><th>Time up</th><th>Time down</th><th>Idle time</th><th>Spin-down latencies</th><th>Mean latency</th><th>I/O bursts</th><th>Spurious spin-ups</th><th>Energy</th></tr>
+{{range .Stats}}<tr><td>{{.Disk}}</td><td>{{.SpinUps}}</td><td>{{.SpinDowns}}</td><td>{{.TimeUp}}s</td><td>{{.TimeDown}}s</td>
+<td>{{with .IdleTime}}{{.}}s{{else}}-{{end}}</td><td>{{range $i, $l := .SpinDownLatencies}}{{if $i}}, {{end}}{{$l}}s{{end}}</td>
+<td>{{printf "%.0f" .MeanSpinDownLatency}}s</td><td>{{.IOBursts}}</td><td>{{.SpuriousSpinUps}}</td><td>{{with .EnergyWh}}{{printf "%.2f" .}} Wh{{else}}-{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Disks</h2>
+<p class="legend"><span style="color:#1e88e5">&#9632; sectors read</span><span style="color:#fb8c00">&#9632; sectors written</span>
+<span style="color:#4caf50">&#9632; up</span><span style="color:#9e9e9e">&#9632; down</span>
+<span style="color:#7b1fa2">| hd-idle spindown</span><span style="color:#0288d1">| hd-idle spinup</span>
+<span style="color:#616161">&#166; marker</span><span style="color:#d32f2f">&#9679; anomaly</span></p>
+{{range .Charts}}
+<h3>{{.Disk}}</h3>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" font-size="10">
+<text x="2" y="{{.IOTop}}">{{.MaxSectors}}</text>
+<text x="2" y="{{.IOBottom}}">0</text>
+<line x1="{{.Left}}" y1="{{.IOBottom}}" x2="{{.Right}}" y2="{{.IOBottom}}" stroke="#bdbdbd"/>
+<polyline points="{{.ReadPoints}}" fill="none" stroke="#1e88e5"/>
+<polyline points="{{.WritePoints}}" fill="none" stroke="#fb8c00"/>
+<text x="2" y="{{.PowerLabelY}}">power</text>
+{{$chart := .}}{{range .PowerBands}}<rect x="{{printf "%.1f" .X}}" y="{{$chart.PowerTop}}" width="{{printf "%.1f" .Width}}" height="{{$chart.PowerSize}}" fill="{{.Color}}"><title>{{.Title}}</title></rect>
+{{end}}{{range .Lines}}<line x1="{{printf "%.1f" .X}}" y1="{{$chart.IOTop}}" x2="{{printf "%.1f" .X}}" y2="{{$chart.PowerTop}}" stroke="{{.Color}}"{{if .Dashed}} stroke-dasharray="4 3"{{end}}><title>{{.Label}}</title></line>
+{{if .Dashed}}<text x="{{printf "%.1f" .X}}" y="{{$chart.MarkerLabelY}}" fill="{{.Color}}">{{.Label}}</text>{{end}}
+{{end}}{{range .Anomalies}}<circle cx="{{printf "%.1f" .X}}" cy="{{$chart.IOTop}}" r="4" fill="{{.Color}}"><title>{{.Label}}</title></circle>
+{{end}}{{range .Ticks}}<text x="{{printf "%.1f" .X}}" y="{{$chart.TickLabelY}}" text-anchor="middle">{{.Label}}</text>
+{{end}}</svg>
+{{end}}
+
+<h2>Events</h2>
+{{if .Events}}<table>
+<tr><th>Time</th><th>Disk</th><th>Event</th><th>Source</th><th>Message</th></tr>
+{{range .Events}}<tr{{if eq .Type "anomaly"}} class="anomaly"{{end}}><td>{{.Time}}</td><td>{{.Disk}}</td><td>{{.Type}}</td><td>{{.Source}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>No events.</p>{{end}}
+
+<h2>Markers</h2>
+{{if .Markers}}<table>
+<tr><th>Time</th><th>Label</th><th>Operation</th><th>Path</th><th>Bytes</th><th>Detail</th></tr>
+{{range .Markers}}<tr><td>{{.Frame}}</td><td>{{.Label}}</td><td>{{.Operation}}</td><td>{{.Path}}</td><td>{{.Bytes}}</td><td>{{.Detail}}</td></tr>
+{{end}}</table>{{else}}<p>No markers.</p>{{end}}
+
+{{range .Environments}}
+<h2>Environment at the {{.Title | lower}}</h2>
+{{with .Environment}}
+<table>
+<tr><th>Captured at</th><td>{{.CapturedAt}}</td></tr>
+<tr><th>Kernel</th><td>{{.Kernel}}</td></tr>
+{{range .HdIdle}}<tr><th>hd-idle</th><td>{{.Binary}} {{.Version}} (sha256 {{.Sha256}})<br>{{range .Cmdline}}{{.}} {{end}}</td></tr>
+{{end}}{{range .Services}}<tr><th>Service</th><td>{{.Name}} {{.Pids}}</td></tr>
+{{end}}</table>
+{{if .HdIdleDefaults}}<h3>/etc/default/hd-idle</h3>
+<pre>{{.HdIdleDefaults}}</pre>{{end}}
+<table>
+<tr><th>Disk</th><th>Devices</th><th>Mount points</th></tr>
+{{range .Topology}}<tr><td>{{.Disk}}</td><td>{{range .Devices}}{{.}} {{end}}</td><td>{{range .MountPoints}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Disk</th><th>Start/stop count</th><th>Load cycle count</th><th>Power cycle count</th><th>Error</th></tr>
+{{range .Smart}}<tr><td>{{.Disk}}</td><td>{{.StartStopCount}}</td><td>{{.LoadCycleCount}}</td><td>{{.PowerCycleCount}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Source</th><th>Mount point</th><th>Type</th></tr>
+{{range .Mounts}}<tr><td>{{.Source}}</td><td>{{.MountPoint}}</td><td>{{.FsType}}</td></tr>
+{{end}}</table>
+{{end}}
+{{else}}<h2>Environment</h2>
+<p>No environment captured.</p>
+{{end}}
+</body>
+</html>
+`))
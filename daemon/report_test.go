@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReportOverlaysEachClaimOnce(t *testing.T) {
+	frames := recordFrames(t,
+		[]string{"", "sda spindown\n", "", "", "", "", "", "", "", "sda spinup\n", ""},
+		[]string{"up", "up", "up", "up", "up", "up", "up", "up", "up", "up", "up"})
+	markers := []marker{{Label: "<copy>", Frame: frames[5].Time.Format("2006-01-02T15:04:05Z07:00")}}
+
+	chart := newReportChart("sda", sessionExportRows(frames, nil, nil, []string{"sda"}), markers,
+		frames[0].Time, frames[len(frames)-1].Time)
+	claims, dashed := 0, 0
+	for _, line := range chart.Lines {
+		if line.Dashed {
+			dashed++
+		} else {
+			claims++
+		}
+	}
+	if claims != 2 || dashed != 1 || len(chart.Anomalies) != 1 {
+		t.Errorf("got %d claims, %d markers and %d anomalies, want 2, 1 and 1", claims, dashed, len(chart.Anomalies))
+	}
+	if len(chart.PowerBands) != 1 || chart.PowerBands[0].Title != "up" {
+		t.Errorf("got power bands %+v", chart.PowerBands)
+	}
+
+	var buffer bytes.Buffer
+	if err := writeSessionReport(&buffer, sessionReport{Session: "s;1", Charts: []reportChart{chart}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), "&lt;copy&gt;") {
+		t.Error("the marker label isn't escaped")
+	}
+	if strings.Contains(buffer.String(), "<script") {
+		t.Error("the report isn't self-contained")
+	}
+}
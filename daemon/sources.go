@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	procDiskstatsFile = "/proc/diskstats"
+	spdSocketFile     = "/tmp/spd.sock"
+)
+
+// diskstatsSource provides the diskstats stored in every frame. restart is
+// called when a recording starts.
+type diskstatsSource interface {
+	restart()
+	diskstats() ([]byte, error)
+}
+
+// powerSource provides the power state of the disks stored in every frame.
+type powerSource interface {
+	restart()
+	power() (map[string]bool, error)
+}
+
+// wattsSource is a powerSource measuring the power drawn by the disks as
+// well, for the last power state it provided.
+type wattsSource interface {
+	watts() map[string]float64
+}
+
+var (
+	diskstatsProvider diskstatsSource = fileDiskstats{path: procDiskstatsFile}
+	powerProvider     powerSource     = &spdPower{socket: spdSocketFile}
+)
+
+// setupSources picks the providers from the command line flags. A script or
+// a replay provides both the diskstats and the power state.
+func setupSources(diskstatsPath, spdSocket string, drives bool, script, replayDir string) error {
+	if spdSocket != "" {
+		powerProvider = &spdPower{socket: spdSocket}
+	}
+	if drives {
+		powerProvider = drivePower{}
+	}
+	if diskstatsPath != "" {
+		info, err := os.Stat(diskstatsPath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			diskstatsProvider = &dirDiskstats{dir: diskstatsPath}
+		} else {
+			diskstatsProvider = fileDiskstats{path: diskstatsPath}
+		}
+	}
+	if script != "" {
+		s, err := parseDiskScript(script)
+		if err != nil {
+			return err
+		}
+		diskstatsProvider, powerProvider = s, s
+	}
+	if replayDir != "" {
+		r, err := newSessionReplay(replayDir)
+		if err != nil {
+			return err
+		}
+		diskstatsProvider, powerProvider = r, r
+	}
+	return nil
+}
+
+// fileDiskstats reads a file in the /proc/diskstats format, /proc/diskstats
+// itself by default.
+type fileDiskstats struct {
+	path string
+}
+
+func (f fileDiskstats) restart() {}
+
+func (f fileDiskstats) diskstats() ([]byte, error) {
+	return os.ReadFile(f.path)
+}
+
+// dirDiskstats reads the files of a directory in name order, one per frame.
+// The last one is repeated once all are read.
+type dirDiskstats struct {
+	mutex sync.Mutex
+	dir   string
+	next  int
+}
+
+func (d *dirDiskstats) restart() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.next = 0
+}
+
+func (d *dirDiskstats) diskstats() ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			files = append(files, e.Name())
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no diskstats files in %s", d.dir)
+	}
+	sort.Strings(files)
+
+	index := min(d.next, len(files)-1)
+	d.next++
+	return os.ReadFile(filepath.Join(d.dir, files[index]))
+}
+
+// spdPower asks spd, or anything answering its API, for the power state,
+// and the watts drawn when spd reports them.
+type spdPower struct {
+	socket    string
+	mutex     sync.Mutex
+	lastWatts map[string]float64
+}
+
+func (s *spdPower) restart() {}
+
+func (s *spdPower) power() (map[string]bool, error) {
+	type Device struct {
+		Id    string   `json:"id"`
+		Up    bool     `json:"up"`
+		Watts *float64 `json:"watts"`
+	}
+	type DevicesResponse struct {
+		Devices []Device `json:"devices"`
+	}
+
+	resp, err := socketClient(s.socket).Get("http://unix/devices")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var responseBody DevicesResponse
+	if err = json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
+		return nil, err
+	}
+	power := make(map[string]bool)
+	watts := make(map[string]float64)
+	for _, device := range responseBody.Devices {
+		power[device.Id] = device.Up
+		if device.Watts != nil {
+			watts[device.Id] = *device.Watts
+		}
+	}
+	s.mutex.Lock()
+	s.lastWatts = watts
+	s.mutex.Unlock()
+	return power, nil
+}
+
+func (s *spdPower) watts() map[string]float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastWatts
+}
+
+var (
+	socketClientsMutex sync.Mutex
+	socketClients      = make(map[string]*http.Client)
+)
+
+// socketClient returns the client of the socket. It is shared, a client per
+// request would leave a connection and its goroutines behind every frame.
+func socketClient(socket string) *http.Client {
+	socketClientsMutex.Lock()
+	defer socketClientsMutex.Unlock()
+	if client, ok := socketClients[socket]; ok {
+		return client
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	socketClients[socket] = client
+	return client
+}
+
+// scriptStep is one step of a disk script. An idle step only waits, the
+// others happen at once.
+type scriptStep struct {
+	Idle   time.Duration
+	Reads  uint64
+	Writes uint64
+	Power  string
+}
+
+type scriptedDisk struct {
+	Name  string
+	Steps []scriptStep
+}
+
+// diskScript generates diskstats and power states from a script that is
+// played from the start of the recording, e.g.
+//
+//	sda: idle 600s then 5 writes then idle 60s then down; sdb: 2 reads
+//
+// A disk comes up with any read or write, "down" and "up" change its power
+// state without I/O. Once the script ends the disks stay as they are.
+type diskScript struct {
+	mutex   sync.Mutex
+	disks   []scriptedDisk
+	started time.Time
+}
+
+func parseDiskScript(script string) (*diskScript, error) {
+	s := &diskScript{started: clockNow()}
+	for _, part := range strings.Split(script, ";") {
+		name, steps, found := strings.Cut(part, ":")
+		if !found {
+			return nil, fmt.Errorf("missing disk name in %q", strings.TrimSpace(part))
+		}
+		disk := scriptedDisk{Name: strings.TrimSpace(name)}
+		for _, step := range strings.Split(steps, " then ") {
+			parsed, err := parseScriptStep(strings.Fields(step))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", disk.Name, err)
+			}
+			disk.Steps = append(disk.Steps, parsed)
+		}
+		s.disks = append(s.disks, disk)
+	}
+	return s, nil
+}
+
+func parseScriptStep(words []string) (scriptStep, error) {
+	switch {
+	case len(words) == 1 && (words[0] == "up" || words[0] == "down"):
+		return scriptStep{Power: words[0]}, nil
+	case len(words) == 2 && words[0] == "idle":
+		idle, err := time.ParseDuration(words[1])
+		if err != nil {
+			return scriptStep{}, fmt.Errorf("invalid idle time %s", words[1])
+		}
+		return scriptStep{Idle: idle}, nil
+	case len(words) == 2 && (words[1] == "reads" || words[1] == "writes"):
+		count, err := strconv.ParseUint(words[0], 10, 64)
+		if err != nil {
+			return scriptStep{}, fmt.Errorf("invalid count %s", words[0])
+		}
+		if words[1] == "reads" {
+			return scriptStep{Reads: count}, nil
+		}
+		return scriptStep{Writes: count}, nil
+	}
+	return scriptStep{}, fmt.Errorf("invalid step %q", strings.Join(words, " "))
+}
+
+func (s *diskScript) restart() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.started = clockNow()
+}
+
+// state plays the script of a disk up to the elapsed time.
+func (d scriptedDisk) state(elapsed time.Duration) (reads, writes uint64, up bool) {
+	up = true
+	at := time.Duration(0)
+	for _, step := range d.Steps {
+		at += step.Idle
+		if at > elapsed {
+			break
+		}
+		reads += step.Reads
+		writes += step.Writes
+		switch {
+		case step.Reads+step.Writes > 0, step.Power == "up":
+			up = true
+		case step.Power == "down":
+			up = false
+		}
+	}
+	return reads, writes, up
+}
+
+func (s *diskScript) elapsed() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return clockNow().Sub(s.started)
+}
+
+func (s *diskScript) diskstats() ([]byte, error) {
+	elapsed := s.elapsed()
+	var content strings.Builder
+	for i, disk := range s.disks {
+		reads, writes, _ := disk.state(elapsed)
+		fmt.Fprintf(&content, "%4d %7d %s %d 0 %d 0 %d 0 %d 0 0 0 0 0 0 0 0 0 0\n",
+			8, i*16, disk.Name, reads, reads*8, writes, writes*8)
+	}
+	return []byte(content.String()), nil
+}
+
+func (s *diskScript) power() (map[string]bool, error) {
+	elapsed := s.elapsed()
+	power := make(map[string]bool)
+	for _, disk := range s.disks {
+		_, _, power[disk.Name] = disk.state(elapsed)
+	}
+	return power, nil
+}
+
+// sessionReplay plays the diskstats and power state of a recorded session,
+// one frame per collected frame. The last frame is repeated once all are
+// played.
+type sessionReplay struct {
+	mutex  sync.Mutex
+	frames []sessionFrame
+	raw    [][]byte
+	next   int
+}
+
+func newSessionReplay(sessionDir string) (*sessionReplay, error) {
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames to replay in %s", sessionDir)
+	}
+	r := &sessionReplay{frames: frames}
+	for _, frame := range frames {
+		content, err := os.ReadFile(filepath.Join(sessionDir, frame.Id, "diskstats"))
+		if err != nil {
+			return nil, err
+		}
+		r.raw = append(r.raw, content)
+	}
+	return r, nil
+}
+
+func (r *sessionReplay) restart() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.next = 0
+}
+
+// diskstats moves the replay to the next frame, power reads the frame the
+// diskstats were last taken from.
+func (r *sessionReplay) diskstats() ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	index := min(r.next, len(r.frames)-1)
+	r.next++
+	return r.raw[index], nil
+}
+
+func (r *sessionReplay) power() (map[string]bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	index := min(max(r.next-1, 0), len(r.frames)-1)
+	power := make(map[string]bool)
+	for disk, state := range r.frames[index].Power {
+		power[disk] = state == "up"
+	}
+	return power, nil
+}
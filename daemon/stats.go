@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// hdIdleDefaultIdleTime is the idle time hd-idle uses when -i isn't given.
+const hdIdleDefaultIdleTime = 600
+
+// diskStatistics are the numbers put in hd-idle pull requests. Times are in
+// seconds. SpinDownDelays are the spin-down latencies minus the configured
+// idle time, i.e. how late hd-idle was.
+type diskStatistics struct {
+	Disk                string   `json:"disk"`
+	SpinUps             int      `json:"spin_ups"`
+	SpinDowns           int      `json:"spin_downs"`
+	TimeUp              int64    `json:"time_up"`
+	TimeDown            int64    `json:"time_down"`
+	IdleTime            *int64   `json:"idle_time,omitempty"`
+	SpinDownLatencies   []int64  `json:"spin_down_latencies"`
+	MeanSpinDownLatency float64  `json:"mean_spin_down_latency"`
+	SpinDownDelays      []int64  `json:"spin_down_delays,omitempty"`
+	IOBursts            int      `json:"io_bursts"`
+	SpuriousSpinUps     int      `json:"spurious_spin_ups"`
+	EnergyWh            *float64 `json:"energy_wh,omitempty"`
+}
+
+// sessionStatistics adds to the session analysis the time every disk spent
+// up and down, the number of I/O bursts, i.e. runs of frames with I/O, and
+// the energy used when spd measured the watts. idleTimes are the ones hd-idle
+// was configured with, nil when unknown.
+func sessionStatistics(frames []sessionFrame, idleTimes *hdIdleIdleTimes) []diskStatistics {
+	var statistics []diskStatistics
+	for _, analysis := range analyzeSession(frames) {
+		disk := analysis.Disk
+		stats := diskStatistics{
+			Disk:              disk,
+			SpinUps:           analysis.SpinUps,
+			SpinDowns:         analysis.SpinDowns,
+			SpinDownLatencies: analysis.SpinDownLatencies,
+			SpuriousSpinUps:   analysis.SpuriousSpinUps,
+		}
+
+		var total int64
+		for _, latency := range analysis.SpinDownLatencies {
+			total += latency
+		}
+		if len(analysis.SpinDownLatencies) > 0 {
+			stats.MeanSpinDownLatency = float64(total) / float64(len(analysis.SpinDownLatencies))
+		}
+		if idleTimes != nil {
+			idleTime := idleTimes.of(disk)
+			stats.IdleTime = &idleTime
+			for _, latency := range analysis.SpinDownLatencies {
+				stats.SpinDownDelays = append(stats.SpinDownDelays, latency-idleTime)
+			}
+		}
+
+		var energy float64
+		var hasWatts, active bool
+		for i := 1; i < len(frames); i++ {
+			elapsed := frames[i].Time.Sub(frames[i-1].Time)
+			switch frames[i-1].Power[disk] {
+			case "up":
+				stats.TimeUp += int64(elapsed / time.Second)
+			case "down":
+				stats.TimeDown += int64(elapsed / time.Second)
+			}
+			if watts, ok := frames[i-1].Watts[disk]; ok {
+				hasWatts = true
+				energy += watts * elapsed.Hours()
+			}
+
+			wasActive := active
+			active = diskActiveInFrame(frames, i, disk)
+			if active && !wasActive {
+				stats.IOBursts++
+			}
+		}
+		if hasWatts {
+			stats.EnergyWh = &energy
+		}
+		statistics = append(statistics, stats)
+	}
+	return statistics
+}
+
+// hdIdleIdleTimes are the idle times in seconds given to hd-idle.
+type hdIdleIdleTimes struct {
+	defaultIdleTime int64
+	disks           map[string]int64
+}
+
+func (t *hdIdleIdleTimes) of(disk string) int64 {
+	if idleTime, ok := t.disks[disk]; ok {
+		return idleTime
+	}
+	return t.defaultIdleTime
+}
+
+// sessionIdleTimes reads the idle times from the arguments of the first
+// hd-idle run or, when the daemon didn't run it, from the command line of the
+// one found running. It returns nil when neither is known.
+func sessionIdleTimes(sessionDir string, mapping map[string]string) (*hdIdleIdleTimes, error) {
+	runs, err := sessionHdIdleRuns(sessionDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) > 0 {
+		return parseIdleTimes(runs[0].Args, mapping), nil
+	}
+	env, err := sessionEnvironment(sessionDir, environmentStartFileName)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil || len(env.HdIdle) == 0 || len(env.HdIdle[0].Cmdline) == 0 {
+		return nil, nil
+	}
+	return parseIdleTimes(env.HdIdle[0].Cmdline[1:], mapping), nil
+}
+
+// parseIdleTimes follows the hd-idle arguments: -i before any -a sets the
+// default idle time, -i after -a sets the one of that disk. Persistent names
+// are turned into kernel names.
+func parseIdleTimes(args []string, mapping map[string]string) *hdIdleIdleTimes {
+	idleTimes := &hdIdleIdleTimes{defaultIdleTime: hdIdleDefaultIdleTime, disks: make(map[string]int64)}
+	var disk string
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-a":
+			i++
+			disk = args[i]
+			if kernelName, ok := mapping[disk]; ok {
+				disk = kernelName
+			}
+			disk = filepath.Base(disk)
+		case "-i":
+			i++
+			idleTime, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				continue
+			}
+			if disk == "" {
+				idleTimes.defaultIdleTime = idleTime
+			} else {
+				idleTimes.disks[disk] = idleTime
+			}
+		}
+	}
+	return idleTimes
+}
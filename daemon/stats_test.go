@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSessionEnergy records the power of a disk through a fake spd reporting
+// the watts: the disk still draws some power in standby, it is down all the
+// same and the energy adds up the watts of every frame. The frames share a
+// connection to spd.
+func TestSessionEnergy(t *testing.T) {
+	dir := t.TempDir()
+	readings := []struct {
+		up    bool
+		watts float64
+	}{{true, 6}, {true, 6}, {false, 0.9}, {false, 0.9}, {true, 7.5}}
+	reading := 0
+	spd := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"devices":[{"id":"sda","up":%t,"watts":%g},{"id":"sdb","up":false}]}`,
+			readings[reading].up, readings[reading].watts)
+	}))
+	socket := filepath.Join(dir, "spd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connections := 0
+	spd.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections++
+		}
+	}
+	spd.Listener = listener
+	spd.Start()
+	defer spd.Close()
+
+	previous := powerProvider
+	powerProvider = &spdPower{socket: socket}
+	defer func() { powerProvider = previous }()
+
+	sessionDir := filepath.Join(dir, "01;1000")
+	for reading = range readings {
+		frameDir := filepath.Join(sessionDir, fmt.Sprint(1000+reading*600))
+		if err = os.MkdirAll(frameDir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(frameDir, "diskstats"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = collectPowerState(frameDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if connections != 1 {
+		t.Errorf("%d connections to spd over %d frames", connections, len(readings))
+	}
+	content, _ := os.ReadFile(filepath.Join(sessionDir, "2200", "watts"))
+	if string(content) != "sda: 0.9\n" {
+		t.Errorf("watts file %q", content)
+	}
+
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stats := range sessionStatistics(frames, nil) {
+		switch stats.Disk {
+		case "sda":
+			if stats.TimeUp != 1200 || stats.TimeDown != 1200 || stats.SpinDowns != 1 || stats.SpinUps != 1 {
+				t.Errorf("sda: %+v", stats)
+			}
+			// 10 minutes at 6 W twice, and at 0.9 W twice
+			want := (6 + 6 + 0.9 + 0.9) * (10 * time.Minute).Hours()
+			if stats.EnergyWh == nil || *stats.EnergyWh < want-1e-9 || *stats.EnergyWh > want+1e-9 {
+				t.Errorf("sda: energy %v, want %g Wh", stats.EnergyWh, want)
+			}
+		case "sdb":
+			if stats.EnergyWh != nil {
+				t.Errorf("sdb: energy %g without watts", *stats.EnergyWh)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// streamBuffer is the number of frames kept for a slow subscriber before
+// newer frames are dropped for it.
+const streamBuffer = 16
+
+// streamFrame is a frame as sent to the subscribers of GET /stream, while it
+// is recorded or replayed.
+type streamFrame struct {
+	Session   string `json:"session"`
+	Replay    bool   `json:"replay"`
+	Id        string `json:"id"`
+	Diskstats string `json:"diskstats"`
+	Log       string `json:"log"`
+	Stdout    string `json:"stdout"`
+	Power     string `json:"power"`
+	ProcIO    string `json:"procio"`
+	PowerMode string `json:"powermode"`
+}
+
+var (
+	subscribersMutex sync.Mutex
+	subscribers      = make(map[chan streamFrame]struct{})
+)
+
+func subscribeFrames() chan streamFrame {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	frames := make(chan streamFrame, streamBuffer)
+	subscribers[frames] = struct{}{}
+	return frames
+}
+
+func unsubscribeFrames(frames chan streamFrame) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	delete(subscribers, frames)
+}
+
+// publishFrame sends the frame to every subscriber without waiting for them.
+func publishFrame(frame streamFrame) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	for frames := range subscribers {
+		select {
+		case frames <- frame:
+		default:
+			log.Printf("Stream subscriber too slow, dropping frame %s", frame.Id)
+		}
+	}
+}
+
+func readStreamFrame(session, frameDir string) (streamFrame, error) {
+	frame := streamFrame{Session: session, Id: filepath.Base(frameDir)}
+	diskstatsBytes, err := os.ReadFile(filepath.Join(frameDir, "diskstats"))
+	if err != nil {
+		return frame, err
+	}
+	frame.Diskstats = string(diskstatsBytes)
+	logBytes, _ := os.ReadFile(filepath.Join(frameDir, "log"))
+	frame.Log = string(logBytes)
+	stdoutBytes, _ := os.ReadFile(filepath.Join(frameDir, "stdout"))
+	frame.Stdout = string(stdoutBytes)
+	powerBytes, _ := os.ReadFile(filepath.Join(frameDir, "power"))
+	frame.Power = string(powerBytes)
+	procIOBytes, _ := os.ReadFile(filepath.Join(frameDir, "procio"))
+	frame.ProcIO = string(procIOBytes)
+	powerModeBytes, _ := os.ReadFile(filepath.Join(frameDir, "powermode"))
+	frame.PowerMode = string(powerModeBytes)
+	return frame, nil
+}
+
+type sessionPlayer struct {
+	session string
+	speed   float64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+var (
+	playerMutex sync.Mutex
+	player      *sessionPlayer
+)
+
+// startReplay plays a recorded session over the stream, keeping the time
+// between frames divided by speed. A replay already running is stopped.
+func startReplay(sessionDir string, speed float64) error {
+	frames, err := loadSessionFrames(sessionDir)
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to replay in %s", filepath.Base(sessionDir))
+	}
+
+	stopReplay()
+	p := &sessionPlayer{
+		session: filepath.Base(sessionDir),
+		speed:   speed,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	playerMutex.Lock()
+	player = p
+	playerMutex.Unlock()
+
+	go p.play(sessionDir, frames)
+	return nil
+}
+
+func stopReplay() {
+	playerMutex.Lock()
+	p := player
+	player = nil
+	playerMutex.Unlock()
+	if p != nil {
+		close(p.stop)
+		<-p.done
+	}
+}
+
+// replayingSession returns the session being replayed, if any.
+func replayingSession() string {
+	playerMutex.Lock()
+	defer playerMutex.Unlock()
+	if player == nil {
+		return ""
+	}
+	return player.session
+}
+
+func (p *sessionPlayer) play(sessionDir string, frames []sessionFrame) {
+	defer close(p.done)
+	log.Printf("Replaying session '%s' at %gx", p.session, p.speed)
+
+	for i, frame := range frames {
+		if i > 0 {
+			wait := time.Duration(float64(frame.Time.Sub(frames[i-1].Time)) / p.speed)
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+		streamed, err := readStreamFrame(p.session, filepath.Join(sessionDir, frame.Id))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		streamed.Replay = true
+		publishFrame(streamed)
+	}
+
+	playerMutex.Lock()
+	if player == p {
+		player = nil
+	}
+	playerMutex.Unlock()
+	log.Printf("Replay of session '%s' finished", p.session)
+}
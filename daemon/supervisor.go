@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultHdIdleBinary  = "/usr/sbin/hd-idle"
+	hdIdleRunsFileName   = "hdidle_runs.json"
+	hdIdleStopTimeout    = 5 * time.Second
+	hdIdleMaxRestartWait = 30 * time.Second
+	// hdIdleHealthyRun is how long hd-idle has to stay up for the restart
+	// delay to start over
+	hdIdleHealthyRun = time.Minute
+)
+
+// hdIdleOptions tell the daemon to run hd-idle itself during a recording
+// instead of relying on an instance started by hand.
+type hdIdleOptions struct {
+	Binary string   `json:"binary"`
+	Args   []string `json:"args"`
+}
+
+type hdIdleRun struct {
+	Binary   string   `json:"binary"`
+	Args     []string `json:"args"`
+	Pid      int      `json:"pid"`
+	Started  string   `json:"started"`
+	Exited   string   `json:"exited,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type hdIdleSupervisor struct {
+	mutex      sync.Mutex
+	options    hdIdleOptions
+	sessionDir string
+	process    *os.Process
+	output     string
+	runs       []hdIdleRun
+	stopped    bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// supervisor and hdidleLogPath are set when a recording starts and stops, and
+// read by the collectors, under supervisorMutex.
+var (
+	supervisorMutex sync.Mutex
+	supervisor      *hdIdleSupervisor
+	hdidleLogPath   = hdidleLogFile
+)
+
+// startHdIdle launches hd-idle for the session and restarts it with an
+// increasing delay whenever it exits before the session ends.
+func startHdIdle(sessionDir string, options hdIdleOptions) error {
+	if options.Binary == "" {
+		options.Binary = defaultHdIdleBinary
+	}
+	var logPath string
+	options.Args, logPath = hdIdleLogArgs(options.Args)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	logFile.Close()
+
+	if pids := processesByComm()["hd-idle"]; len(pids) > 0 {
+		log.Printf("hd-idle is already running with pid %v, both instances will act on the disks", pids)
+	}
+
+	s := &hdIdleSupervisor{
+		options:    options,
+		sessionDir: sessionDir,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	supervisorMutex.Lock()
+	supervisor = s
+	hdidleLogPath = logPath
+	supervisorMutex.Unlock()
+	go s.run()
+	return nil
+}
+
+// hdIdleLogArgs returns the args of hd-idle and the log file it writes, the
+// one given with -l. The log file is added when -l is missing, or given to -l
+// when it ends the args, so the recording still tails a log.
+func hdIdleLogArgs(args []string) ([]string, string) {
+	logPath := hdidleLogFile
+	found := false
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-l" {
+			logPath, found = args[i+1], true
+		}
+	}
+	args = append([]string(nil), args...)
+	if len(args) > 0 && args[len(args)-1] == "-l" {
+		return append(args, logPath), logPath
+	}
+	if !found {
+		args = append(args, "-l", logPath)
+	}
+	return args, logPath
+}
+
+func stopHdIdle() {
+	supervisorMutex.Lock()
+	s := supervisor
+	supervisor = nil
+	hdidleLogPath = hdidleLogFile
+	supervisorMutex.Unlock()
+	if s != nil {
+		s.terminate()
+	}
+}
+
+// currentSupervisor returns the supervisor of the recording, if any, and the
+// log hd-idle writes to.
+func currentSupervisor() (*hdIdleSupervisor, string) {
+	supervisorMutex.Lock()
+	defer supervisorMutex.Unlock()
+	return supervisor, hdidleLogPath
+}
+
+func (s *hdIdleSupervisor) run() {
+	defer close(s.done)
+
+	wait := time.Second
+	for {
+		started := time.Now()
+		s.runOnce()
+		if time.Since(started) >= hdIdleHealthyRun {
+			wait = time.Second
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, hdIdleMaxRestartWait)
+	}
+}
+
+func (s *hdIdleSupervisor) runOnce() {
+	run := hdIdleRun{
+		Binary:  s.options.Binary,
+		Args:    s.options.Args,
+		Started: time.Now().Format(time.RFC3339),
+	}
+
+	cmd := exec.Command(s.options.Binary, s.options.Args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.finishRun(run, err)
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		s.finishRun(run, err)
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		s.finishRun(run, err)
+		return
+	}
+
+	run.Pid = cmd.Process.Pid
+	s.mutex.Lock()
+	if s.stopped {
+		// terminate ran while the process was starting and didn't see it
+		_ = cmd.Process.Kill()
+	}
+	s.process = cmd.Process
+	s.runs = append(s.runs, run)
+	s.mutex.Unlock()
+	s.saveRuns()
+	log.Printf("Started hd-idle with pid %d", run.Pid)
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go s.capture(stdout, "", &readers)
+	go s.capture(stderr, "stderr: ", &readers)
+	readers.Wait()
+
+	err = cmd.Wait()
+	s.mutex.Lock()
+	s.process = nil
+	s.runs = s.runs[:len(s.runs)-1]
+	s.mutex.Unlock()
+	s.finishRun(run, err)
+}
+
+func (s *hdIdleSupervisor) capture(pipe io.Reader, prefix string, readers *sync.WaitGroup) {
+	defer readers.Done()
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		s.mutex.Lock()
+		s.output += prefix + scanner.Text() + "\n"
+		s.mutex.Unlock()
+	}
+}
+
+func (s *hdIdleSupervisor) finishRun(run hdIdleRun, err error) {
+	run.Exited = time.Now().Format(time.RFC3339)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		run.ExitCode = &code
+	case errors.As(err, &exitErr):
+		code := exitErr.ExitCode()
+		run.ExitCode = &code
+		run.Error = err.Error()
+	default:
+		run.Error = err.Error()
+	}
+	log.Printf("hd-idle run ended. %s", run.Error)
+
+	s.mutex.Lock()
+	s.runs = append(s.runs, run)
+	s.mutex.Unlock()
+	s.saveRuns()
+}
+
+func (s *hdIdleSupervisor) terminate() {
+	s.mutex.Lock()
+	s.stopped = true
+	close(s.stop)
+	process := s.process
+	s.mutex.Unlock()
+	if process != nil {
+		_ = process.Signal(syscall.SIGTERM)
+		select {
+		case <-s.done:
+			return
+		case <-time.After(hdIdleStopTimeout):
+			_ = process.Kill()
+		}
+	}
+	<-s.done
+}
+
+// drainOutput returns the stdout and stderr lines written since the last call.
+func (s *hdIdleSupervisor) drainOutput() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	output := s.output
+	s.output = ""
+	return output
+}
+
+func (s *hdIdleSupervisor) saveRuns() {
+	s.mutex.Lock()
+	content, err := json.Marshal(s.runs)
+	s.mutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err = os.MkdirAll(s.sessionDir, 0750); err != nil {
+		log.Println(err)
+		return
+	}
+	if err = os.WriteFile(filepath.Join(s.sessionDir, hdIdleRunsFileName), content, 0644); err != nil {
+		log.Println(err)
+	}
+}
+
+func sessionHdIdleRuns(sessionDir string) ([]hdIdleRun, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, hdIdleRunsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []hdIdleRun
+	if err = json.Unmarshal(content, &runs); err != nil {
+		return nil, fmt.Errorf("unable to parse %s. %w", hdIdleRunsFileName, err)
+	}
+	return runs, nil
+}
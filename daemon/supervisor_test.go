@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStopHdIdleWhileStarting(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "hd-idle.log")
+	for i := 0; i < 20; i++ {
+		err := startHdIdle(dir, hdIdleOptions{Binary: "/bin/sh", Args: []string{"-c", "exec sleep 60", "-l", logPath}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, path := currentSupervisor(); s == nil || path != logPath {
+			t.Fatalf("got supervisor %v logging to %s", s, path)
+		}
+		time.Sleep(time.Duration(i%4) * time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			stopHdIdle()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(2 * hdIdleStopTimeout):
+			t.Fatalf("stopHdIdle hung on iteration %d", i)
+		}
+		if s, path := currentSupervisor(); s != nil || path != hdidleLogFile {
+			t.Fatalf("got supervisor %v logging to %s after stopping", s, path)
+		}
+	}
+}
+
+func TestHdIdleLogArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []string
+		logPath string
+	}{
+		{nil, []string{"-l", hdidleLogFile}, hdidleLogFile},
+		{[]string{"-i", "30"}, []string{"-i", "30", "-l", hdidleLogFile}, hdidleLogFile},
+		{[]string{"-l", "/tmp/a.log", "-i", "30"}, []string{"-l", "/tmp/a.log", "-i", "30"}, "/tmp/a.log"},
+		{[]string{"-i", "30", "-l"}, []string{"-i", "30", "-l", hdidleLogFile}, hdidleLogFile},
+		{[]string{"-l", "/tmp/a.log", "-l"}, []string{"-l", "/tmp/a.log", "-l", "/tmp/a.log"}, "/tmp/a.log"},
+	}
+	for _, test := range tests {
+		args, logPath := hdIdleLogArgs(test.args)
+		if !reflect.DeepEqual(args, test.want) || logPath != test.logPath {
+			t.Errorf("hdIdleLogArgs(%q) = %q, %s, want %q, %s", test.args, args, logPath, test.want, test.logPath)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysBlockDir is a variable so the tests can point it to a directory of
+// their own.
+var sysBlockDir = "/sys/block"
+
+func collectSysfs(frameDir string, disks []string) error {
+	if len(disks) == 0 {
+		var err error
+		disks, err = physicalDisks()
+		if err != nil {
+			return err
+		}
+	}
+
+	var content = ""
+	for _, disk := range disks {
+		diskDir := filepath.Join(sysBlockDir, disk)
+		if _, err := os.Stat(diskDir); err != nil {
+			content += fmt.Sprintf("%s: not present\n", disk)
+			continue
+		}
+
+		content += disk + "\n"
+		content += fmt.Sprintf("  stat: %s\n", strings.Join(strings.Fields(readSysfsValue(diskDir, "stat")), " "))
+		content += fmt.Sprintf("  state: %s\n", readSysfsValue(diskDir, "device/state"))
+		content += fmt.Sprintf("  runtime_status: %s\n", readSysfsValue(diskDir, "device/power/runtime_status"))
+		content += fmt.Sprintf("  control: %s\n", readSysfsValue(diskDir, "device/power/control"))
+		content += fmt.Sprintf("  rotational: %s\n", readSysfsValue(diskDir, "queue/rotational"))
+		if temperature, ok := driveTemperature(diskDir); ok {
+			content += fmt.Sprintf("  temperature: %.1f°C\n", temperature)
+		}
+	}
+
+	return os.WriteFile(filepath.Join(frameDir, "sysfs"), []byte(content), 0644)
+}
+
+// physicalDisks lists the block devices backed by a real device, leaving out
+// loop, ram, device-mapper and md devices.
+func physicalDisks() ([]string, error) {
+	entries, err := os.ReadDir(sysBlockDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(sysBlockDir, e.Name(), "device")); err != nil {
+			continue
+		}
+		disks = append(disks, e.Name())
+	}
+	return disks, nil
+}
+
+func readSysfsValue(diskDir, attribute string) string {
+	value, err := os.ReadFile(filepath.Join(diskDir, attribute))
+	if err != nil {
+		return "-"
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// driveTemperature reads the first temperature exposed by the drivetemp hwmon
+// driver. The value is reported by the kernel in millidegrees.
+func driveTemperature(diskDir string) (float64, bool) {
+	inputs, _ := filepath.Glob(filepath.Join(diskDir, "device", "hwmon", "hwmon*", "temp1_input"))
+	for _, input := range inputs {
+		value, err := os.ReadFile(input)
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.Atoi(strings.TrimSpace(string(value)))
+		if err != nil {
+			continue
+		}
+		return float64(milli) / 1000, true
+	}
+	return 0, false
+}
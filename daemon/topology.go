@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	sysClassBlock    = "/sys/class/block"
+	topologyFileName = "topology.json"
+)
+
+// diskTopology describes a physical disk with the partitions and stacked
+// devices (device-mapper, md) built on top of it and where they are mounted.
+type diskTopology struct {
+	Disk        string   `json:"disk"`
+	Devices     []string `json:"devices"`
+	MountPoints []string `json:"mount_points"`
+}
+
+// buildTopology walks the holders of every physical disk and its partitions,
+// so a dm or md device spanning several disks is listed under each of them.
+func buildTopology() ([]diskTopology, error) {
+	disks, err := physicalDisks()
+	if err != nil {
+		return nil, err
+	}
+
+	var topology []diskTopology
+	devicesDisks := make(map[string][]string)
+	for _, disk := range disks {
+		devices := []string{disk}
+		entries, _ := os.ReadDir(filepath.Join(sysBlockDir, disk))
+		for _, e := range entries {
+			if _, err := os.Stat(filepath.Join(sysBlockDir, disk, e.Name(), "partition")); err == nil {
+				devices = append(devices, e.Name())
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, device := range devices {
+			seen[device] = true
+		}
+		for i := 0; i < len(devices); i++ {
+			holders, _ := os.ReadDir(filepath.Join(sysClassBlock, devices[i], "holders"))
+			for _, holder := range holders {
+				if !seen[holder.Name()] {
+					seen[holder.Name()] = true
+					devices = append(devices, holder.Name())
+				}
+			}
+		}
+
+		for _, device := range devices {
+			devicesDisks[device] = append(devicesDisks[device], disk)
+		}
+		topology = append(topology, diskTopology{Disk: disk, Devices: devices})
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		devicePath, err := filepath.EvalSymlinks(filepath.Join(sysDevBlock, m.MajorMinor))
+		if err != nil {
+			continue
+		}
+		for _, disk := range devicesDisks[filepath.Base(devicePath)] {
+			for i := range topology {
+				if topology[i].Disk == disk {
+					topology[i].MountPoints = append(topology[i].MountPoints, m.MountPoint)
+				}
+			}
+		}
+	}
+
+	return topology, nil
+}
+
+func saveTopology(sessionDir string) error {
+	topology, err := buildTopology()
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(topology)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(sessionDir, 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionDir, topologyFileName), content, 0644)
+}
+
+// sessionTopology returns the topology captured when the session started, or
+// the current one for sessions recorded without it.
+func sessionTopology(sessionDir string) ([]diskTopology, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, topologyFileName))
+	if os.IsNotExist(err) {
+		return buildTopology()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var topology []diskTopology
+	err = json.Unmarshal(content, &topology)
+	return topology, err
+}
+
+// diskMountPoints returns the mount points whose block device lives on one of
+// the given disks.
+func diskMountPoints(disks []string) ([]string, error) {
+	topology, err := buildTopology()
+	if err != nil {
+		return nil, err
+	}
+
+	var mountPoints []string
+	for _, t := range topology {
+		for _, d := range disks {
+			if d == t.Disk {
+				mountPoints = append(mountPoints, t.MountPoints...)
+				break
+			}
+		}
+	}
+	return mountPoints, nil
+}
+
+// diskActivity rolls up the activity of the devices of each physical disk
+// between two diskstats snapshots. The sectors are the ones of the disk
+// itself, as the I/O of partitions and stacked devices ends up on it.
+func diskActivity(topology []diskTopology, previous, current map[string]diskStat) string {
+	var content = ""
+	for _, t := range topology {
+		var activeDevices []string
+		for _, device := range t.Devices {
+			if current[device].activeSince(previous[device]) {
+				activeDevices = append(activeDevices, device)
+			}
+		}
+		if len(activeDevices) == 0 {
+			continue
+		}
+		sort.Strings(activeDevices)
+		disk := current[t.Disk]
+		content += fmt.Sprintf("%s: %d sectors read, %d sectors written (%s)\n", t.Disk,
+			disk.SectorsRead-min(previous[t.Disk].SectorsRead, disk.SectorsRead),
+			disk.SectorsWritten-min(previous[t.Disk].SectorsWritten, disk.SectorsWritten),
+			strings.Join(activeDevices, ", "))
+	}
+	return content
+}
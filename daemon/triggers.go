@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/madflojo/tasks"
+)
+
+const (
+	recordTriggersFileName = "triggers.json"
+	eventPollInterval      = 5 * time.Second
+	defaultSessionName     = "{{.Trigger}}"
+)
+
+// recordEvents are what an event trigger can wait for: an hd-idle process
+// starting, a disk being plugged in or a file system being mounted.
+var recordEvents = []string{"hdidle", "disk", "mount"}
+
+// recordTrigger starts recordings on a cron schedule or on an event. A
+// recording lasts Duration seconds or, for events, until what started it
+// goes away, e.g. the disk is unplugged. Schedules and durations are on the
+// wall clock, the sessions are named after clockNow like the other ones.
+type recordTrigger struct {
+	Name  string       `json:"name"`
+	Cron  string       `json:"cron,omitempty"`
+	Event *recordEvent `json:"event,omitempty"`
+	// SessionName is a template for the name of the sessions, with the
+	// fields Trigger, Date, Time and Match, e.g. "nightly-{{.Date}}"
+	SessionName string `json:"session_name"`
+	Duration    int64  `json:"duration"`
+	// MaxRuns limits the number of sessions the trigger starts, 0 for no
+	// limit
+	MaxRuns int           `json:"max_runs"`
+	Options recordOptions `json:"options"`
+}
+
+type recordEvent struct {
+	Type string `json:"type"`
+	// Match is the disk or the mount point waited for, any when empty
+	Match string `json:"match"`
+}
+
+type recordTriggerState struct {
+	trigger  recordTrigger
+	schedule *cronSchedule
+	name     *template.Template
+	task     string
+	next     time.Time
+	runs     int
+	present  map[string]bool
+	session  string
+	match    string
+	stopTask string
+}
+
+type recordTriggerStatus struct {
+	recordTrigger
+	Runs    int    `json:"runs"`
+	NextRun string `json:"next_run,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+var (
+	triggersMutex   sync.Mutex
+	recordTriggers  = make(map[string]*recordTriggerState)
+	triggersDataDir string
+	eventPollTask   string
+)
+
+// loadRecordTriggers sets up the triggers saved in the data directory.
+func loadRecordTriggers(dataDir string) {
+	triggersMutex.Lock()
+	triggersDataDir = dataDir
+	triggersMutex.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(dataDir, recordTriggersFileName))
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to read recording triggers. %s", err)
+		return
+	}
+	var triggers []recordTrigger
+	if err = json.Unmarshal(content, &triggers); err != nil {
+		log.Printf("Unable to parse %s. %s", recordTriggersFileName, err)
+		return
+	}
+	for _, trigger := range triggers {
+		if err = addRecordTrigger(trigger); err != nil {
+			log.Printf("Recording trigger '%s' disabled. %s", trigger.Name, err)
+		}
+	}
+}
+
+// addRecordTrigger sets up the trigger, replacing the one with the same name.
+func addRecordTrigger(trigger recordTrigger) error {
+	if trigger.Name == "" {
+		return fmt.Errorf("the trigger needs a name")
+	}
+	if (trigger.Cron == "") == (trigger.Event == nil) {
+		return fmt.Errorf("the trigger needs either a cron expression or an event")
+	}
+	if trigger.SessionName == "" {
+		trigger.SessionName = defaultSessionName
+	}
+	name, err := template.New(trigger.Name).Parse(trigger.SessionName)
+	if err != nil {
+		return err
+	}
+	state := &recordTriggerState{trigger: trigger, name: name}
+	if trigger.Cron != "" {
+		if trigger.Duration <= 0 {
+			return fmt.Errorf("a cron trigger needs the duration of the recordings")
+		}
+		if state.schedule, err = parseCron(trigger.Cron); err != nil {
+			return err
+		}
+	} else if !isRecordEvent(trigger.Event.Type) {
+		return fmt.Errorf("unknown event '%s', expected one of %s", trigger.Event.Type, strings.Join(recordEvents, ", "))
+	}
+
+	triggersMutex.Lock()
+	defer triggersMutex.Unlock()
+	if previous, ok := recordTriggers[trigger.Name]; ok {
+		previous.cancel()
+	}
+	recordTriggers[trigger.Name] = state
+	if state.schedule != nil {
+		if err = state.scheduleNext(); err != nil {
+			delete(recordTriggers, trigger.Name)
+			return err
+		}
+	} else if err = startEventPolling(); err != nil {
+		delete(recordTriggers, trigger.Name)
+		return err
+	}
+	return saveRecordTriggers()
+}
+
+func removeRecordTrigger(name string) (bool, error) {
+	triggersMutex.Lock()
+	defer triggersMutex.Unlock()
+	state, ok := recordTriggers[name]
+	if !ok {
+		return false, nil
+	}
+	state.cancel()
+	delete(recordTriggers, name)
+	return true, saveRecordTriggers()
+}
+
+func listRecordTriggers() []recordTriggerStatus {
+	triggersMutex.Lock()
+	defer triggersMutex.Unlock()
+	statuses := []recordTriggerStatus{}
+	for _, state := range recordTriggers {
+		status := recordTriggerStatus{recordTrigger: state.trigger, Runs: state.runs, Session: state.session}
+		if !state.next.IsZero() {
+			status.NextRun = state.next.Format(time.RFC3339)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// saveRecordTriggers keeps the triggers across restarts. triggersMutex must
+// be held.
+func saveRecordTriggers() error {
+	triggers := []recordTrigger{}
+	for _, state := range recordTriggers {
+		triggers = append(triggers, state.trigger)
+	}
+	sort.Slice(triggers, func(i, j int) bool {
+		return triggers[i].Name < triggers[j].Name
+	})
+	content, err := json.Marshal(triggers)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(triggersDataDir, recordTriggersFileName), content, 0644)
+}
+
+// cancel removes the tasks of the trigger and stops the recording it
+// started, which would otherwise go on without its stop task. triggersMutex
+// must be held.
+func (s *recordTriggerState) cancel() {
+	if s.task != "" {
+		scheduler.Del(s.task)
+	}
+	if s.stopTask != "" {
+		scheduler.Del(s.stopTask)
+	}
+	if s.session != "" {
+		s.stop()
+	}
+}
+
+// scheduleNext adds a task running at the next time of the cron schedule.
+// The scheduler runs on the wall clock, so does the schedule, even on a
+// virtual clock. triggersMutex must be held.
+func (s *recordTriggerState) scheduleNext() error {
+	s.next = s.schedule.next(time.Now())
+	if s.next.IsZero() {
+		return fmt.Errorf("cron expression '%s' never matches", s.trigger.Cron)
+	}
+	id, err := scheduler.Add(&tasks.Task{
+		Interval: time.Until(s.next),
+		RunOnce:  true,
+		TaskFunc: func() error {
+			triggersMutex.Lock()
+			defer triggersMutex.Unlock()
+			if recordTriggers[s.trigger.Name] != s {
+				return nil
+			}
+			s.fire("")
+			return s.scheduleNext()
+		},
+		ErrFunc: func(err error) {
+			log.Printf("Recording trigger '%s' stopped. %s", s.trigger.Name, err)
+		},
+	})
+	s.task = id
+	return err
+}
+
+// startEventPolling starts looking for the events every poll interval, once.
+// triggersMutex must be held.
+func startEventPolling() error {
+	if eventPollTask != "" {
+		return nil
+	}
+	id, err := scheduler.Add(&tasks.Task{
+		Interval:          eventPollInterval,
+		RunSingleInstance: true,
+		TaskFunc:          pollRecordEvents,
+	})
+	eventPollTask = id
+	return err
+}
+
+// pollRecordEvents fires the event triggers on what appeared since the last
+// poll, and stops the recordings without a duration whose event went away.
+// What is there when a trigger is added doesn't fire it.
+func pollRecordEvents() error {
+	triggersMutex.Lock()
+	defer triggersMutex.Unlock()
+
+	current := make(map[string]map[string]bool)
+	for _, state := range recordTriggers {
+		if state.trigger.Event == nil {
+			continue
+		}
+		eventType := state.trigger.Event.Type
+		if _, ok := current[eventType]; !ok {
+			present, err := presentForEvent(eventType)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			current[eventType] = present
+		}
+		present := make(map[string]bool)
+		for item := range current[eventType] {
+			if state.trigger.Event.Match == "" || state.trigger.Event.Match == item {
+				present[item] = true
+			}
+		}
+
+		if state.present != nil {
+			if state.session != "" && state.trigger.Duration <= 0 && !present[state.match] {
+				state.stop()
+			}
+			var appeared []string
+			for item := range present {
+				if !state.present[item] {
+					appeared = append(appeared, item)
+				}
+			}
+			sort.Strings(appeared)
+			if len(appeared) > 0 {
+				state.fire(appeared[0])
+			}
+		}
+		state.present = present
+	}
+	return nil
+}
+
+// presentForEvent lists the hd-idle processes, the disks or the mount points.
+func presentForEvent(eventType string) (map[string]bool, error) {
+	present := make(map[string]bool)
+	switch eventType {
+	case "hdidle":
+		for _, pid := range processesByComm()["hd-idle"] {
+			present[strconv.Itoa(pid)] = true
+		}
+	case "disk":
+		disks, err := physicalDisks()
+		if err != nil {
+			return nil, err
+		}
+		for _, disk := range disks {
+			present[disk] = true
+		}
+	case "mount":
+		mounts, err := readMounts()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range mounts {
+			present[m.MountPoint] = true
+		}
+	}
+	return present, nil
+}
+
+// fire starts a recording unless one is in progress or the trigger reached
+// its limit. triggersMutex must be held.
+func (s *recordTriggerState) fire(match string) {
+	if s.trigger.MaxRuns > 0 && s.runs >= s.trigger.MaxRuns {
+		log.Printf("Recording trigger '%s' reached its limit of %d runs", s.trigger.Name, s.trigger.MaxRuns)
+		return
+	}
+	if recordingSession() != "" {
+		log.Printf("Recording trigger '%s' skipped, a recording is in progress", s.trigger.Name)
+		return
+	}
+
+	now := clockNow()
+	var name bytes.Buffer
+	err := s.name.Execute(&name, struct {
+		Trigger, Date, Time, Match string
+	}{s.trigger.Name, now.Format("2006-01-02"), now.Format("150405"), strings.Trim(strings.ReplaceAll(match, "/", "_"), "_")})
+	if err != nil {
+		log.Printf("Recording trigger '%s' skipped. %s", s.trigger.Name, err)
+		return
+	}
+	session, err := startRecording(triggersDataDir, strings.ReplaceAll(name.String(), "/", "_"), s.trigger.Options)
+	if err != nil {
+		log.Printf("Recording trigger '%s' failed. %s", s.trigger.Name, err)
+		return
+	}
+	s.runs++
+	s.session = session
+	s.match = match
+	log.Printf("Recording trigger '%s' started session '%s'", s.trigger.Name, session)
+
+	if s.trigger.Duration > 0 {
+		s.stopTask, err = scheduler.Add(&tasks.Task{
+			Interval: time.Duration(s.trigger.Duration) * time.Second,
+			RunOnce:  true,
+			TaskFunc: func() error {
+				triggersMutex.Lock()
+				defer triggersMutex.Unlock()
+				if s.session == session {
+					s.stop()
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// stop stops the recording started by the trigger, unless it was already
+// stopped by hand. triggersMutex must be held.
+func (s *recordTriggerState) stop() {
+	if recordingSession() == s.session {
+		stopRecording(s.trigger.Name)
+	}
+	s.session = ""
+	s.match = ""
+	s.stopTask = ""
+}
+
+func isRecordEvent(event string) bool {
+	for _, e := range recordEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultVerifyWindow = 30 * time.Second
+
+// verifyWindow is how far apart an hd-idle claim and the power state seen by
+// spd or reported by the drive may be and still match.
+var verifyWindow = defaultVerifyWindow
+
+// sessionEvent is a change in a session: an hd-idle claim, a power
+// transition seen by spd or reported by the drive, or an anomaly found when
+// checking them against each other.
+type sessionEvent struct {
+	Time    string `json:"time"`
+	Frame   string `json:"frame"`
+	Disk    string `json:"disk"`
+	Type    string `json:"type"`
+	Source  string `json:"source"`
+	Message string `json:"message,omitempty"`
+}
+
+type timedEvent struct {
+	Time  time.Time
+	Frame string
+	Disk  string
+	Kind  string
+}
+
+// verifySession checks what hd-idle claims against the power state. A
+// spindown claim must be followed by the disk seen down, and every disk
+// going down must come with a spindown claim.
+func verifySession(frames []sessionFrame, mapping map[string]string, window time.Duration) []sessionEvent {
+	claims := hdIdleClaims(frames, mapping)
+	spd := powerTransitions(frames, func(f sessionFrame) map[string]string { return f.Power }, "down")
+	drive := powerTransitions(frames, func(f sessionFrame) map[string]string { return f.PowerMode }, "standby")
+
+	var events []sessionEvent
+	for _, claim := range claims {
+		events = append(events, newSessionEvent(claim, "spin"+claim.Kind+"_claim", "hd-idle", ""))
+	}
+	for _, t := range spd {
+		events = append(events, newSessionEvent(t, "power_"+t.Kind, "spd", ""))
+	}
+	for _, t := range drive {
+		events = append(events, newSessionEvent(t, "drive_"+t.Kind, "drive", ""))
+	}
+
+	for _, claim := range claims {
+		if claim.Kind != "down" {
+			continue
+		}
+		if state, ok := stateWithin(frames, claim, window, func(f sessionFrame) map[string]string { return f.Power }); ok && state != "down" {
+			events = append(events, newSessionEvent(claim, "anomaly", "spd",
+				"claimed spindown but still drawing power"))
+		}
+		if state, ok := stateWithin(frames, claim, window, func(f sessionFrame) map[string]string { return f.PowerMode }); ok && state != "standby" {
+			events = append(events, newSessionEvent(claim, "anomaly", "drive",
+				fmt.Sprintf("claimed spindown but the drive reports %s", state)))
+		}
+	}
+	for _, t := range spd {
+		if t.Kind == "down" && !claimedAround(claims, t, window) {
+			events = append(events, newSessionEvent(t, "anomaly", "spd", "disk down without hd-idle action"))
+		}
+	}
+	for _, t := range drive {
+		if t.Kind == "down" && !claimedAround(claims, t, window) {
+			events = append(events, newSessionEvent(t, "anomaly", "drive", "disk down without hd-idle action"))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	return events
+}
+
+func newSessionEvent(e timedEvent, eventType, source, message string) sessionEvent {
+	return sessionEvent{
+		Time:    e.Time.Format(time.RFC3339),
+		Frame:   e.Frame,
+		Disk:    e.Disk,
+		Type:    eventType,
+		Source:  source,
+		Message: message,
+	}
+}
+
+// hdIdleClaims reads the "sda spindown" and "sda spinup" lines hd-idle writes
+// to stdout. Persistent names are turned into kernel names.
+func hdIdleClaims(frames []sessionFrame, mapping map[string]string) []timedEvent {
+	var claims []timedEvent
+	for _, frame := range frames {
+		for _, line := range strings.Split(frame.Stdout, "\n") {
+			cols := strings.Fields(line)
+			if len(cols) != 2 || (cols[1] != "spindown" && cols[1] != "spinup") {
+				continue
+			}
+			disk := cols[0]
+			if kernelName, ok := mapping[disk]; ok {
+				disk = kernelName
+			}
+			kind := "up"
+			if cols[1] == "spindown" {
+				kind = "down"
+			}
+			claims = append(claims, timedEvent{Time: frame.Time, Frame: frame.Id, Disk: filepath.Base(disk), Kind: kind})
+		}
+	}
+	return claims
+}
+
+// powerTransitions lists the frames where a disk goes from up to down or the
+// other way round. down is the state meaning down for the source.
+func powerTransitions(frames []sessionFrame, states func(sessionFrame) map[string]string, down string) []timedEvent {
+	var transitions []timedEvent
+	for i := 1; i < len(frames); i++ {
+		for disk, current := range states(frames[i]) {
+			previous, ok := states(frames[i-1])[disk]
+			if !ok || !knownPowerState(previous) || !knownPowerState(current) {
+				continue
+			}
+			switch {
+			case previous != down && current == down:
+				transitions = append(transitions, timedEvent{Time: frames[i].Time, Frame: frames[i].Id, Disk: disk, Kind: "down"})
+			case previous == down && current != down:
+				transitions = append(transitions, timedEvent{Time: frames[i].Time, Frame: frames[i].Id, Disk: disk, Kind: "up"})
+			}
+		}
+	}
+	sort.SliceStable(transitions, func(i, j int) bool {
+		if transitions[i].Time.Equal(transitions[j].Time) {
+			return transitions[i].Disk < transitions[j].Disk
+		}
+		return transitions[i].Time.Before(transitions[j].Time)
+	})
+	return transitions
+}
+
+func knownPowerState(state string) bool {
+	return state != "" && !strings.HasPrefix(state, "unknown")
+}
+
+// stateWithin returns the state of the disk in the first frame of the window
+// after the claim where it is down, or in the last frame of the window when
+// it never is. ok is false when the source has no state for the disk.
+func stateWithin(frames []sessionFrame, claim timedEvent, window time.Duration, states func(sessionFrame) map[string]string) (state string, ok bool) {
+	for _, frame := range frames {
+		if frame.Time.Before(claim.Time) || frame.Time.After(claim.Time.Add(window)) {
+			continue
+		}
+		current, found := states(frame)[claim.Disk]
+		if !found || !knownPowerState(current) {
+			continue
+		}
+		state, ok = current, true
+		if current == "down" || current == "standby" {
+			return state, ok
+		}
+	}
+	return state, ok
+}
+
+func claimedAround(claims []timedEvent, transition timedEvent, window time.Duration) bool {
+	for _, claim := range claims {
+		if claim.Kind == "down" && claim.Disk == transition.Disk &&
+			!claim.Time.Before(transition.Time.Add(-window)) && !claim.Time.After(transition.Time.Add(window)) {
+			return true
+		}
+	}
+	return false
+}
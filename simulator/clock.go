@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const clockTick = 100 * time.Millisecond
+
+// virtualClock is the time seen by the simulated hd-idle. It moves forward
+// with the real time multiplied by speed, and can be advanced at will through
+// the control socket. A speed of 0 stops it, so it only moves when advanced.
+type virtualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	speed float64
+	// onAdvance is called with the clock locked for every poll instant
+	// crossed while advancing, in order.
+	onAdvance func(now time.Time)
+	interval  time.Duration
+	nextPoll  time.Time
+}
+
+func newVirtualClock(start time.Time, speed float64, interval time.Duration, onAdvance func(now time.Time)) *virtualClock {
+	return &virtualClock{
+		now:       start,
+		speed:     speed,
+		interval:  interval,
+		nextPoll:  start.Add(interval),
+		onAdvance: onAdvance,
+	}
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) Speed() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.speed
+}
+
+func (c *virtualClock) SetSpeed(speed float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.speed = speed
+}
+
+// Advance moves the clock forward by d, running every poll crossed on the way
+// so the outcome doesn't depend on how big the step is.
+func (c *virtualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	target := c.now.Add(d)
+	for !c.nextPoll.After(target) {
+		c.now = c.nextPoll
+		c.onAdvance(c.now)
+		c.nextPoll = c.nextPoll.Add(c.interval)
+	}
+	c.now = target
+}
+
+// run advances the clock along with the real time until stop is closed.
+func (c *virtualClock) run(stop chan struct{}) {
+	ticker := time.NewTicker(clockTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if speed := c.Speed(); speed > 0 {
+				c.Advance(time.Duration(float64(clockTick) * speed))
+			}
+		}
+	}
+}
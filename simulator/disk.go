@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	scsiDiskMajor     = 8
+	sectorsPerRequest = 8
+)
+
+// simulatedDisk is the state the kernel would report for a disk.
+type simulatedDisk struct {
+	Name   string `json:"name"`
+	Major  int    `json:"major"`
+	Minor  int    `json:"minor"`
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
+	Up     bool   `json:"up"`
+}
+
+type diskSet struct {
+	mutex         sync.Mutex
+	disks         map[string]*simulatedDisk
+	diskstatsPath string
+}
+
+func newDiskSet(names []string, diskstatsPath string) *diskSet {
+	set := &diskSet{disks: make(map[string]*simulatedDisk), diskstatsPath: diskstatsPath}
+	for i, name := range names {
+		set.disks[name] = &simulatedDisk{Name: name, Major: scsiDiskMajor, Minor: i * 16, Up: true}
+	}
+	return set
+}
+
+func (s *diskSet) get(name string) (simulatedDisk, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	disk, ok := s.disks[name]
+	if !ok {
+		return simulatedDisk{}, false
+	}
+	return *disk, true
+}
+
+func (s *diskSet) list() []simulatedDisk {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var disks []simulatedDisk
+	for _, disk := range s.disks {
+		disks = append(disks, *disk)
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].Minor < disks[j].Minor
+	})
+	return disks
+}
+
+// addIO completes reads and writes on the disk, spinning it up first when it
+// is down, like the kernel does for a real request.
+func (s *diskSet) addIO(name string, reads, writes uint64) error {
+	s.mutex.Lock()
+	disk, ok := s.disks[name]
+	if !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("unknown disk %s", name)
+	}
+	disk.Reads += reads
+	disk.Writes += writes
+	if reads+writes > 0 {
+		disk.Up = true
+	}
+	s.mutex.Unlock()
+	return s.writeDiskstats()
+}
+
+// setPower changes the power state without any I/O showing in diskstats,
+// e.g. a power mode check that wakes the disk up.
+func (s *diskSet) setPower(name string, up bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	disk, ok := s.disks[name]
+	if !ok {
+		return fmt.Errorf("unknown disk %s", name)
+	}
+	disk.Up = up
+	return nil
+}
+
+func (s *diskSet) counters(name string) (reads, writes uint64, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	disk, ok := s.disks[name]
+	if !ok {
+		return 0, 0, false
+	}
+	return disk.Reads, disk.Writes, true
+}
+
+// writeDiskstats writes the disks in the /proc/diskstats format. The file is
+// replaced at once so a reader never sees it half written.
+func (s *diskSet) writeDiskstats() error {
+	if s.diskstatsPath == "" {
+		return nil
+	}
+
+	var content strings.Builder
+	for _, disk := range s.list() {
+		fmt.Fprintf(&content, "%4d %7d %s %d 0 %d 0 %d 0 %d 0 0 0 0 0 0 0 0 0 0\n",
+			disk.Major, disk.Minor, disk.Name,
+			disk.Reads, disk.Reads*sectorsPerRequest,
+			disk.Writes, disk.Writes*sectorsPerRequest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.diskstatsPath), 0755); err != nil {
+		return err
+	}
+	tmp := s.diskstatsPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.diskstatsPath)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordingThroughSimulator runs the daemon against the simulator on the
+// shared virtual clock: a few writes, hd-idle spinning the disk down after
+// its idle time and a write waking it up again, all in a moment.
+func TestRecordingThroughSimulator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the daemon")
+	}
+	dir := t.TempDir()
+	hdsim := filepath.Join(dir, "bin", "hdsim")
+	hdtd := filepath.Join(dir, "bin", "hdtd")
+	build(t, ".", hdsim)
+	build(t, "../daemon", hdtd)
+
+	hdtdSocket := filepath.Join(dir, "hdtd.sock")
+	simSocket := filepath.Join(dir, "hdsim.sock")
+	spdSocket := filepath.Join(dir, "spd.sock")
+	diskstats := filepath.Join(dir, "diskstats")
+	logFile := filepath.Join(dir, "hd-idle.log")
+	if err := os.WriteFile(diskstats, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := exec.Command(hdtd, "-socket", hdtdSocket, "-simulator", simSocket,
+		"-diskstats", diskstats, "-spd", spdSocket)
+	daemon.Env = append(os.Environ(), "XDG_CONFIG_HOME="+dir, "GIN_MODE=release")
+	daemonLog, err := os.Create(filepath.Join(dir, "hdtd.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemonLog.Close()
+	daemon.Stdout, daemon.Stderr = daemonLog, daemonLog
+	if err = daemon.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = daemon.Process.Kill()
+		_ = daemon.Wait()
+		if t.Failed() {
+			output, _ := os.ReadFile(daemonLog.Name())
+			t.Logf("daemon output:\n%s", output)
+		}
+	}()
+	waitForSocket(t, hdtdSocket)
+
+	var started struct {
+		Session string `json:"session"`
+	}
+	post(t, hdtdSocket, "/record", map[string]any{
+		"name":   "e2e",
+		"action": "start",
+		"hdidle": map[string]any{
+			"binary": hdsim,
+			"args": []string{"--socket", simSocket, "--spd", spdSocket, "--diskstats", diskstats,
+				"--speed", "0", "-i", "30", "-l", logFile},
+		},
+	}, &started)
+	if started.Session == "" {
+		t.Fatal("no session started")
+	}
+	waitForSocket(t, simSocket)
+	waitForSocket(t, spdSocket)
+
+	// 2 frames, the writes, 12 frames while the disk spins down after 30s,
+	// a write waking it up and 2 more frames
+	advance(t, hdtdSocket, 10)
+	post(t, simSocket, "/disks/sda/io", map[string]any{"writes": 3}, nil)
+	advance(t, hdtdSocket, 60)
+	post(t, simSocket, "/disks/sda/io", map[string]any{"writes": 1}, nil)
+	advance(t, hdtdSocket, 10)
+	post(t, hdtdSocket, "/record", map[string]any{"name": "e2e", "action": "stop"}, nil)
+
+	sessionDir := filepath.Join(dir, "hdtd", started.Session)
+	entries, err := os.ReadDir(sessionDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			frames = append(frames, entry.Name())
+		}
+	}
+	if len(frames) != 16 {
+		t.Fatalf("recorded %d frames, want 16: %v", len(frames), frames)
+	}
+
+	var stdout, hdIdleLog, power []string
+	for _, frame := range frames {
+		stdout = append(stdout, readFrameFile(t, sessionDir, frame, "stdout"))
+		hdIdleLog = append(hdIdleLog, readFrameFile(t, sessionDir, frame, "log"))
+		power = append(power, strings.TrimSpace(readFrameFile(t, sessionDir, frame, "power")))
+	}
+	all := strings.Join(stdout, "")
+	if strings.Count(all, "sda spindown") != 1 || strings.Count(all, "sda spinup") != 1 {
+		t.Errorf("hd-idle output over the frames:\n%s", all)
+	}
+	// hd-idle polls every 3s, it sees the writes at 12s and spins the disk
+	// down at 42s, in the frame of 45s
+	if !strings.Contains(stdout[8], "sda spindown") {
+		t.Errorf("sda didn't spin down after being idle for 30s: %q", stdout)
+	}
+	if !strings.Contains(strings.Join(hdIdleLog, ""), "disk: sda, running: ") {
+		t.Errorf("no spin cycle in the hd-idle log of the frames")
+	}
+	if !strings.Contains(power[0], "up") || !strings.Contains(power[13], "down") || !strings.Contains(power[15], "up") {
+		t.Errorf("power over the frames: %q", power)
+	}
+	if fields := strings.Fields(readFrameFile(t, sessionDir, frames[15], "diskstats")); len(fields) < 8 || fields[7] != "4" {
+		t.Errorf("diskstats of the last frame: %v", fields)
+	}
+}
+
+func build(t *testing.T, dir, output string) {
+	t.Helper()
+	cmd := exec.Command("go", "build", "-o", output, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("unable to build %s. %s\n%s", dir, err, out)
+	}
+}
+
+func unixClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func waitForSocket(t *testing.T, socket string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("nothing listening on %s", socket)
+}
+
+func post(t *testing.T, socket, path string, request, response any) {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := unixClient(socket).Post("http://unix"+path, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: %s %s", path, resp.Status, content)
+	}
+	if response != nil {
+		if err = json.Unmarshal(content, response); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// advance moves the daemon's clock, which moves the simulator's first.
+func advance(t *testing.T, socket string, seconds int) {
+	t.Helper()
+	post(t, socket, "/clock", map[string]any{"advance": seconds}, nil)
+}
+
+func readFrameFile(t *testing.T, sessionDir, frame, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(sessionDir, frame, name))
+	if err != nil {
+		t.Fatal(fmt.Errorf("frame %s: %w", frame, err))
+	}
+	return string(content)
+}
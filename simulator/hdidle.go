@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const defaultIdleTime = 600 * time.Second
+
+// hdIdleConfig holds the hd-idle command line options the simulator honours.
+type hdIdleConfig struct {
+	DefaultIdle time.Duration
+	Idle        map[string]time.Duration
+	LogFile     string
+	Debug       bool
+}
+
+// diskState mirrors what hd-idle keeps for every disk it watches.
+type diskState struct {
+	Name       string
+	Reads      uint64
+	Writes     uint64
+	IdleTime   time.Duration
+	LastIoAt   time.Time
+	SpinUpAt   time.Time
+	SpinDownAt time.Time
+	SpunDown   bool
+}
+
+type hdIdle struct {
+	config hdIdleConfig
+	disks  *diskSet
+	states []*diskState
+}
+
+// parseHdIdleArgs reads the arguments the same way hd-idle does: an -i before
+// any -a sets the default idle time, an -i after -a sets it for that disk.
+// Options without effect on a simulated disk (-c, -s, -p) are accepted and
+// ignored.
+func parseHdIdleArgs(args []string) (hdIdleConfig, error) {
+	config := hdIdleConfig{DefaultIdle: defaultIdleTime, Idle: make(map[string]time.Duration)}
+	disk := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-d":
+			config.Debug = true
+		case "-a", "-i", "-l", "-c", "-s", "-p":
+			if i+1 >= len(args) {
+				return config, fmt.Errorf("missing value for %s", args[i])
+			}
+			i++
+			switch args[i-1] {
+			case "-a":
+				disk = filepath.Base(args[i])
+			case "-i":
+				seconds, err := strconv.Atoi(args[i])
+				if err != nil {
+					return config, fmt.Errorf("invalid idle time %s", args[i])
+				}
+				if disk == "" {
+					config.DefaultIdle = time.Duration(seconds) * time.Second
+				} else {
+					config.Idle[disk] = time.Duration(seconds) * time.Second
+				}
+			case "-l":
+				config.LogFile = args[i]
+			}
+		case "-t":
+			return config, fmt.Errorf("-t is not supported by the simulator, use the control socket")
+		default:
+			return config, fmt.Errorf("unknown option %s", args[i])
+		}
+	}
+	return config, nil
+}
+
+// pollInterval is a tenth of the shortest idle time, as in hd-idle.
+func (c hdIdleConfig) pollInterval() time.Duration {
+	interval := c.DefaultIdle
+	for _, idle := range c.Idle {
+		if idle != 0 && (interval == 0 || idle < interval) {
+			interval = idle
+		}
+	}
+	if interval/10 < time.Second {
+		return time.Second
+	}
+	return interval / 10
+}
+
+func (c hdIdleConfig) idleTime(disk string) time.Duration {
+	if idle, ok := c.Idle[disk]; ok {
+		return idle
+	}
+	return c.DefaultIdle
+}
+
+func newHdIdle(config hdIdleConfig, disks *diskSet, now time.Time) *hdIdle {
+	h := &hdIdle{config: config, disks: disks}
+	for _, disk := range disks.list() {
+		h.states = append(h.states, &diskState{
+			Name:     disk.Name,
+			Reads:    disk.Reads,
+			Writes:   disk.Writes,
+			IdleTime: config.idleTime(disk.Name),
+			LastIoAt: now,
+			SpinUpAt: now,
+		})
+	}
+	return h
+}
+
+// observe is one iteration of the hd-idle main loop at the given time.
+func (h *hdIdle) observe(now time.Time) {
+	for _, ds := range h.states {
+		reads, writes, ok := h.disks.counters(ds.Name)
+		if !ok {
+			continue
+		}
+
+		if reads != ds.Reads || writes != ds.Writes {
+			if ds.SpunDown {
+				fmt.Printf("%s spinup\n", ds.Name)
+				h.logSpinUp(ds, now)
+				ds.SpinUpAt = now
+			}
+			ds.Reads, ds.Writes = reads, writes
+			ds.LastIoAt = now
+			ds.SpunDown = false
+			continue
+		}
+
+		idleDuration := now.Sub(ds.LastIoAt)
+		if h.config.Debug {
+			fmt.Printf("disk=%s command=ata spunDown=%t reads=%d writes=%d idleTime=%d idleDuration=%d\n",
+				ds.Name, ds.SpunDown, ds.Reads, ds.Writes, int(ds.IdleTime.Seconds()), int(idleDuration.Seconds()))
+		}
+		if !ds.SpunDown && ds.IdleTime != 0 && idleDuration >= ds.IdleTime {
+			fmt.Printf("%s spindown\n", ds.Name)
+			if err := h.disks.setPower(ds.Name, false); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			ds.SpinDownAt = now
+			ds.SpunDown = true
+		}
+	}
+}
+
+// logSpinUp appends the spin cycle that just ended to the log file, in the
+// same format as hd-idle.
+func (h *hdIdle) logSpinUp(ds *diskState, now time.Time) {
+	if h.config.LogFile == "" {
+		return
+	}
+	file, err := os.OpenFile(h.config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "date: %s, time: %s, disk: %s, running: %d, stopped: %d\n",
+		now.Format("2006-01-02"), now.Format("15:04:05"), ds.Name,
+		int(ds.SpinDownAt.Sub(ds.SpinUpAt).Seconds()), int(now.Sub(ds.SpinDownAt).Seconds()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSocketFile    = "/tmp/hdsim.sock"
+	defaultDiskstatsFile = "/tmp/hdsim/diskstats"
+)
+
+// options are the simulator's own settings. They use the "--" prefix so they
+// can't be mistaken for the hd-idle options given next to them.
+type options struct {
+	Disks     []string
+	Diskstats string
+	Socket    string
+	Spd       string
+	Speed     float64
+	Start     time.Time
+}
+
+func main() {
+	opts, hdIdleArgs, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(1)
+	}
+	config, err := parseHdIdleArgs(hdIdleArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(1)
+	}
+
+	disks := newDiskSet(opts.Disks, opts.Diskstats)
+	if err = disks.writeDiskstats(); err != nil {
+		panic(err)
+	}
+	hdidle := newHdIdle(config, disks, opts.Start)
+	clock := newVirtualClock(opts.Start, opts.Speed, config.pollInterval(), hdidle.observe)
+
+	gin.SetMode(gin.ReleaseMode)
+	if opts.Spd != "" {
+		go serve(opts.Spd, spdRouter(disks))
+	}
+	go clock.run(make(chan struct{}))
+	serve(opts.Socket, controlRouter(clock, disks))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: hdsim [--disks sda,sdb] [--diskstats file] [--socket file] [--spd file]
+             [--speed factor] [--start RFC3339 time] [hd-idle options]
+
+hd-idle options: -i idle_time [-a disk -i idle_time ...] [-l logfile] [-d]`)
+}
+
+func parseArgs(args []string) (options, []string, error) {
+	opts := options{
+		Disks:     []string{"sda"},
+		Diskstats: defaultDiskstatsFile,
+		Socket:    defaultSocketFile,
+		Speed:     1,
+		Start:     time.Now().Truncate(time.Second),
+	}
+	var hdIdleArgs []string
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			hdIdleArgs = append(hdIdleArgs, args[i])
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, nil, fmt.Errorf("missing value for %s", args[i])
+		}
+		name, value := args[i], args[i+1]
+		i++
+		switch name {
+		case "--disks":
+			opts.Disks = strings.Split(value, ",")
+		case "--diskstats":
+			opts.Diskstats = value
+		case "--socket":
+			opts.Socket = value
+		case "--spd":
+			opts.Spd = value
+		case "--speed":
+			speed, err := strconv.ParseFloat(value, 64)
+			if err != nil || speed < 0 {
+				return opts, nil, fmt.Errorf("invalid speed %s", value)
+			}
+			opts.Speed = speed
+		case "--start":
+			start, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return opts, nil, fmt.Errorf("invalid start time %s", value)
+			}
+			opts.Start = start
+		default:
+			return opts, nil, fmt.Errorf("unknown option %s", name)
+		}
+	}
+	return opts, hdIdleArgs, nil
+}
+
+func controlRouter(clock *virtualClock, disks *diskSet) *gin.Engine {
+	router := gin.New()
+
+	router.GET("/clock", func(c *gin.Context) {
+		type Response struct {
+			Now   string  `json:"now"`
+			Speed float64 `json:"speed"`
+		}
+		c.JSON(http.StatusOK, Response{Now: clock.Now().Format(time.RFC3339), Speed: clock.Speed()})
+	})
+
+	router.POST("/clock", func(c *gin.Context) {
+		type Request struct {
+			Advance int64    `json:"advance"`
+			Speed   *float64 `json:"speed"`
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Speed != nil {
+			if *request.Speed < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "speed must not be negative"})
+				return
+			}
+			clock.SetSpeed(*request.Speed)
+		}
+		if request.Advance > 0 {
+			clock.Advance(time.Duration(request.Advance) * time.Second)
+		}
+		c.JSON(http.StatusOK, gin.H{"now": clock.Now().Format(time.RFC3339)})
+	})
+
+	router.GET("/disks", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"disks": disks.list()})
+	})
+
+	router.POST("/disks/:id/io", func(c *gin.Context) {
+		type Request struct {
+			Reads  uint64 `json:"reads"`
+			Writes uint64 `json:"writes"`
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := disks.addIO(c.Param("id"), request.Reads, request.Writes); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	router.POST("/disks/:id/power", func(c *gin.Context) {
+		type Request struct {
+			Up bool `json:"up"`
+		}
+		var request Request
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := disks.setPower(c.Param("id"), request.Up); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	return router
+}
+
+// spdRouter answers like spd, so the daemon and the scenario scripts read the
+// power state of the simulated disks instead of the smart plugs.
+func spdRouter(disks *diskSet) *gin.Engine {
+	router := gin.New()
+
+	type Device struct {
+		Id string `json:"id"`
+		Up bool   `json:"up"`
+	}
+
+	router.GET("/devices", func(c *gin.Context) {
+		var devices []Device
+		for _, disk := range disks.list() {
+			devices = append(devices, Device{Id: disk.Name, Up: disk.Up})
+		}
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	})
+
+	router.GET("/devices/:id", func(c *gin.Context) {
+		disk, ok := disks.get(c.Param("id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"up": disk.Up})
+	})
+
+	return router
+}
+
+func serve(socketFile string, router *gin.Engine) {
+	_ = os.Remove(socketFile)
+	listener, err := net.Listen("unix", socketFile)
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chmod(socketFile, 0777); err != nil {
+		panic(err)
+	}
+	if err = http.Serve(listener, router); err != nil {
+		panic(err)
+	}
+}
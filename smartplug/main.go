@@ -34,7 +34,7 @@ func main() {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			devices = append(devices, Device{Id: deviceId, Up: status.Up})
+			devices = append(devices, Device{Id: deviceId, Up: status.Up, Watts: status.Watts})
 		}
 
 		c.JSON(http.StatusOK, DevicesResponse{devices})
@@ -74,8 +74,9 @@ func main() {
 }
 
 type Device struct {
-	Id string `json:"id"`
-	Up bool   `json:"up"`
+	Id    string  `json:"id"`
+	Up    bool    `json:"up"`
+	Watts float64 `json:"watts"`
 }
 
 type DevicesResponse struct {
@@ -83,7 +84,8 @@ type DevicesResponse struct {
 }
 
 type DeviceStatusResponse struct {
-	Up bool `json:"up"`
+	Up    bool    `json:"up"`
+	Watts float64 `json:"watts"`
 }
 
 func smartPlugStatus(baseUrl string) (DeviceStatusResponse, error) {
@@ -100,5 +102,5 @@ func smartPlugStatus(baseUrl string) (DeviceStatusResponse, error) {
 		return DeviceStatusResponse{}, err
 	}
 
-	return DeviceStatusResponse{Up: response.Apower > 0}, nil
+	return DeviceStatusResponse{Up: response.Apower > 0, Watts: response.Apower}, nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+type Tolerances struct {
+	SpinDownLatency float64 `json:"spin_down_latency"`
+	SpuriousSpinUps int     `json:"spurious_spin_ups"`
+	SpinUps         int     `json:"spin_ups"`
+	Anomalies       int     `json:"anomalies"`
+}
+
+type ComparedDisk struct {
+	Disk                string  `json:"disk"`
+	SpinUps             int     `json:"spin_ups"`
+	SpinDowns           int     `json:"spin_downs"`
+	MeanSpinDownLatency float64 `json:"mean_spin_down_latency"`
+	SpuriousSpinUps     int     `json:"spurious_spin_ups"`
+}
+
+type ComparedSession struct {
+	Session   string         `json:"session"`
+	Origin    string         `json:"origin"`
+	Disks     []ComparedDisk `json:"disks"`
+	Anomalies map[string]int `json:"anomalies"`
+}
+
+type Regression struct {
+	Session string `json:"session"`
+	Disk    string `json:"disk"`
+	Message string `json:"message"`
+}
+
+type Comparison struct {
+	Baseline    string            `json:"baseline"`
+	Sessions    []ComparedSession `json:"sessions"`
+	Regressions []Regression      `json:"regressions"`
+	Error       string            `json:"error"`
+}
+
+// runCompare implements "hdt compare". It exits with 1 when a session
+// regressed against the baseline, the first one, so it can be used in
+// scripts.
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: hdt compare [options] <baseline session> <session> [...]\n")
+		flags.PrintDefaults()
+	}
+	marker := flags.String("marker", "", "align the sessions on the first marker with this label instead of their start")
+	var tolerances Tolerances
+	flags.Float64Var(&tolerances.SpinDownLatency, "latency", 10, "seconds the spin-down latency may grow")
+	flags.IntVar(&tolerances.SpinUps, "spin-ups", 0, "spin-ups that may be added")
+	flags.IntVar(&tolerances.SpuriousSpinUps, "spurious", 0, "spurious spin-ups that may be added")
+	flags.IntVar(&tolerances.Anomalies, "anomalies", 0, "anomalies that may be added")
+	_ = flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+
+	request, err := json.Marshal(struct {
+		Sessions   []string   `json:"sessions"`
+		Marker     string     `json:"marker"`
+		Tolerances Tolerances `json:"tolerances"`
+	}{flags.Args(), *marker, tolerances})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	body, err := sendDaemon("/compare", string(request))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var comparison Comparison
+	if err = json.Unmarshal([]byte(body), &comparison); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse response body. %s\n", err.Error())
+		return 2
+	}
+	if comparison.Error != "" {
+		fmt.Fprintf(os.Stderr, "server error: %s\n", comparison.Error)
+		return 2
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tDISK\tSPIN-UPS\tSPIN-DOWNS\tSPIN-DOWN LATENCY\tSPURIOUS SPIN-UPS\tANOMALIES")
+	for _, session := range comparison.Sessions {
+		for _, disk := range session.Disks {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.0fs\t%d\t%d\n", session.Session, disk.Disk, disk.SpinUps, disk.SpinDowns,
+				disk.MeanSpinDownLatency, disk.SpuriousSpinUps, session.Anomalies[disk.Disk])
+		}
+	}
+	_ = w.Flush()
+
+	fmt.Println()
+	if len(comparison.Regressions) == 0 {
+		fmt.Printf("No regressions against %s\n", comparison.Baseline)
+		return 0
+	}
+	fmt.Printf("Regressions against %s:\n", comparison.Baseline)
+	for _, r := range comparison.Regressions {
+		fmt.Printf("  %s %s: %s\n", r.Session, r.Disk, r.Message)
+	}
+	return 1
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+type Environment struct {
+	CapturedAt string `json:"captured_at"`
+	Kernel     string `json:"kernel"`
+	HdIdle     []struct {
+		Pid     int      `json:"pid"`
+		Binary  string   `json:"binary"`
+		Sha256  string   `json:"sha256"`
+		Version string   `json:"version"`
+		Cmdline []string `json:"cmdline"`
+	} `json:"hd_idle"`
+	HdIdleDefaults string         `json:"hd_idle_defaults"`
+	Topology       []DiskTopology `json:"topology"`
+	Mounts         []struct {
+		MountPoint string `json:"mount_point"`
+		FsType     string `json:"fs_type"`
+		Source     string `json:"source"`
+	} `json:"mounts"`
+	Services []struct {
+		Name string `json:"name"`
+		Pids []int  `json:"pids"`
+	} `json:"services"`
+	Smart []struct {
+		Disk            string `json:"disk"`
+		StartStopCount  string `json:"start_stop_count"`
+		LoadCycleCount  string `json:"load_cycle_count"`
+		PowerCycleCount string `json:"power_cycle_count"`
+		Error           string `json:"error"`
+	} `json:"smart"`
+}
+
+func (e *Environment) String() string {
+	if e == nil {
+		return "Not captured.\n"
+	}
+
+	text := fmt.Sprintf("Captured at: %s\nKernel: %s\n", e.CapturedAt, e.Kernel)
+	if len(e.HdIdle) == 0 {
+		text += "hd-idle: not running\n"
+	}
+	for _, h := range e.HdIdle {
+		text += fmt.Sprintf("hd-idle: pid %d, %s (%s)\n  sha256: %s\n  cmdline: %s\n",
+			h.Pid, h.Binary, h.Version, h.Sha256, strings.Join(h.Cmdline, " "))
+	}
+	if e.HdIdleDefaults != "" {
+		text += "/etc/default/hd-idle:\n"
+		for _, line := range strings.Split(strings.TrimSpace(e.HdIdleDefaults), "\n") {
+			text += "  " + line + "\n"
+		}
+	}
+	text += "Disks:\n"
+	for _, t := range e.Topology {
+		text += fmt.Sprintf("  %s: %s, mounted on %s\n", t.Disk,
+			strings.Join(t.Devices, " "), strings.Join(t.MountPoints, " "))
+	}
+	text += "Mounts:\n"
+	for _, m := range e.Mounts {
+		text += fmt.Sprintf("  %s on %s (%s)\n", m.Source, m.MountPoint, m.FsType)
+	}
+	text += "Services touching disks:\n"
+	for _, s := range e.Services {
+		text += fmt.Sprintf("  %s %v\n", s.Name, s.Pids)
+	}
+	text += "SMART:\n"
+	for _, s := range e.Smart {
+		if s.Error != "" {
+			text += fmt.Sprintf("  %s: %s\n", s.Disk, s.Error)
+			continue
+		}
+		text += fmt.Sprintf("  %s: start/stop %s, load cycles %s, power cycles %s\n",
+			s.Disk, s.StartStopCount, s.LoadCycleCount, s.PowerCycleCount)
+	}
+	return text
+}
+
+func newEnvironmentView() *tview.TextView {
+	view := newDataTextView("Environment [esc[]")
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			app.SetRoot(flex, true)
+		}
+		return event
+	})
+	return view
+}
+
+func showEnvironment() {
+	if sessionId == "" {
+		logsView.SetText("Select a session to see its environment.")
+		return
+	}
+
+	go func() {
+		start, end, err := requestEnvironmentFromDaemon(sessionId)
+		if err != nil {
+			logsView.SetText("Error loading environment. " + err.Error())
+			app.Draw()
+			return
+		}
+		app.QueueUpdateDraw(func() {
+			environmentView.SetText(tview.Escape(
+				"Session start\n\n" + start.String() + "\nSession end\n\n" + end.String()))
+			app.SetRoot(environmentView, true)
+		})
+	}()
+}
+
+func requestEnvironmentFromDaemon(id string) (*Environment, *Environment, error) {
+	client, err := openClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Get("http://unix/sessions/" + id + "/environment")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	type Response struct {
+		Start *Environment `json:"start"`
+		End   *Environment `json:"end"`
+		Error string       `json:"error"`
+	}
+	var response Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse response body. %s", err.Error())
+	}
+	if response.Error != "" {
+		return nil, nil, fmt.Errorf("server error: %s", response.Error)
+	}
+	return response.Start, response.End, nil
+}
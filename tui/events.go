@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type Event struct {
+	Time    string `json:"time"`
+	Frame   string `json:"frame"`
+	Disk    string `json:"disk"`
+	Type    string `json:"type"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// anomalies holds the anomalies of the selected session by frame id.
+var anomalies map[string][]Event
+
+func loadAnomalies(id string) (int, error) {
+	anomalies = make(map[string][]Event)
+	events, err := requestEventsFromDaemon(id)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, event := range events {
+		if event.Type == "anomaly" {
+			anomalies[event.Frame] = append(anomalies[event.Frame], event)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// frameAnomalies describes the anomalies found in the frame, if any.
+func frameAnomalies(frame Frame) string {
+	text := ""
+	for _, event := range anomalies[frame.Id] {
+		text += fmt.Sprintf("[red]Anomaly (%s): %s %s[-] ", event.Source, event.Disk, event.Message)
+	}
+	return text
+}
+
+func requestEventsFromDaemon(id string) ([]Event, error) {
+	client, err := openClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get("http://unix/sessions/" + id + "/events")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	type Response struct {
+		Events []Event `json:"events"`
+		Error  string  `json:"error"`
+	}
+	var response Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to parse response body. %s", err.Error())
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("server error: %s", response.Error)
+	}
+	return response.Events, nil
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,14 +24,17 @@ const (
 		"[white:gray]Select [⮠][-:-] " +
 		"[white:gray]Reload [r[][-:-] " +
 		"[white:gray]Filter [f[][-:-] " +
+		"[white:gray]Follow [l[][-:-] " +
 		"[white:gray]Rec start/stop [^r][-:-] " +
 		"[white:gray]Quit [q[][-:-]"
 	frameHelp = "[white:gray]Next [→][⇧→][^→][-:-] " +
 		"[white:gray]Previous [←][⇧←][^←][-:-] " +
 		"[white:gray]Scroll [↑↓][-:-] " +
 		"[white:gray]Back [esc[][-:-] " +
+		"[white:gray]Environment [e[][-:-] " +
 		"[white:gray]Reload [r[][-:-] " +
 		"[white:gray]Filter [f[][-:-] " +
+		"[white:gray]Follow [l[][-:-] " +
 		"[white:gray]Rec start/stop [^r][-:-] " +
 		"[white:gray]Quit [q[][-:-]"
 
@@ -55,6 +60,20 @@ type Frame struct {
 	Log       string `json:"log"`
 	Stdout    string `json:"stdout"`
 	Power     string `json:"power"`
+	ProcIO    string `json:"procio"`
+	PowerMode string `json:"powermode"`
+}
+
+type DiskTopology struct {
+	Disk        string   `json:"disk"`
+	Devices     []string `json:"devices"`
+	MountPoints []string `json:"mount_points"`
+}
+
+type Session struct {
+	Frames      []Frame           `json:"frames"`
+	Topology    []DiskTopology    `json:"topology"`
+	DiskMapping map[string]string `json:"disk_mapping"`
 }
 
 func (f Frame) timestamp() string {
@@ -73,7 +92,7 @@ func (f Frame) adaptedDiskstats(filter string) string {
 		}
 
 		deviceName := cols[2]
-		if filter != "" && !strings.Contains(deviceName, filter) {
+		if filter != "" && !strings.Contains(deviceName, filter) && !onDisk(deviceName, filter) {
 			continue
 		}
 
@@ -91,12 +110,38 @@ func (f Frame) adaptedDiskstats(filter string) string {
 	return strings.Join(lines, "\n")
 }
 
+// onDisk tells whether the device is the given physical disk, one of its
+// partitions or a device stacked on top of it.
+func onDisk(device, disk string) bool {
+	for _, t := range topology {
+		if t.Disk != disk {
+			continue
+		}
+		for _, d := range t.Devices {
+			if d == device {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (f Frame) adaptedLog() string {
-	for disk, path := range diskMapping {
-		redacted := fmt.Sprintf("%s [%s]", path, disk)
-		f.Log = strings.ReplaceAll(f.Log, disk, redacted)
+	// longest names first, so a disk name doesn't replace the beginning of the
+	// name of one of its partitions
+	names := make([]string, 0, len(diskMapping))
+	for name := range diskMapping {
+		names = append(names, name)
 	}
-	return f.Log
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+
+	var replacements []string
+	for _, name := range names {
+		replacements = append(replacements, name, fmt.Sprintf("%s [%s]", diskMapping[name], name))
+	}
+	return strings.NewReplacer(replacements...).Replace(f.Log)
 }
 
 var (
@@ -109,14 +154,18 @@ var (
 	recordingView    *tview.TextView
 	right            *tview.Flex
 	statsView        *tview.TextView
+	procIOView       *tview.TextView
 	hdIdleLogView    *tview.TextView
 	powerView        *tview.TextView
 	hdIdleStdoutView *tview.TextView
 	helpView         *tview.TextView
 	flex             *tview.Flex
 
-	frames     []Frame
-	frameIndex int
+	sessionId       string
+	frames          []Frame
+	frameIndex      int
+	topology        []DiskTopology
+	environmentView *tview.TextView
 
 	statsViewLine        int
 	hdIdleLogViewLine    int
@@ -130,6 +179,13 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReport(os.Args[2:]))
+	}
+
 	dim := tcell.StyleDefault.Dim(true)
 
 	paginationView = tview.NewTextView()
@@ -154,11 +210,13 @@ func main() {
 		SetBorderStyle(dim).
 		SetBackgroundColor(backgroundColor)
 	statsView = newDataTextView("/proc/diskstats")
+	procIOView = newDataTextView("process I/O")
 	hdIdleLogView = newDataTextView("hd-idle log")
 	powerView = newDataTextView("device power")
 	hdIdleStdoutView = newDataTextView("hd-idle stdout")
 	logsView = newDataTextView("")
 	recordingView = newDataTextView("")
+	environmentView = newEnvironmentView()
 
 	sessionsList := tview.NewList()
 	sessionsList.SetBackgroundColor(backgroundColor).
@@ -225,6 +283,9 @@ func main() {
 	paginationColumn := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(paginationView, 0, 1, false).
 		AddItem(framesView, 0, 6, false)
+	stats := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(statsView, 0, 3, false).
+		AddItem(procIOView, 0, 1, false)
 	logs := tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(powerView, 0, 1, false).
 		AddItem(hdIdleStdoutView, 0, 1, false).
@@ -235,7 +296,7 @@ func main() {
 	helpView.SetBackgroundColor(backgroundColor)
 	right = tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(paginationColumn, 3, 1, false).
-		AddItem(statsView, 0, 3, false).
+		AddItem(stats, 0, 3, false).
 		AddItem(logs, 0, 1, false)
 	right.SetBorder(true).SetBorderStyle(tcell.StyleDefault)
 	right.SetFocusFunc(func() {
@@ -330,6 +391,10 @@ func main() {
 				go refreshAvailableSessions(sessionsList)
 			case 'f':
 				showFilterModal()
+			case 'e':
+				showEnvironment()
+			case 'l':
+				toggleFollow()
 			}
 		}
 
@@ -423,16 +488,30 @@ func refreshAvailableSessions(sessionsList *tview.List) {
 		}()
 
 		go func() {
-			frames, err = requestSessionFromDaemon(sessions[i])
+			session, err := requestSessionFromDaemon(sessions[i])
 			if err != nil {
 				clearRightPanel()
 				logsView.SetText("Error loading session. " + err.Error())
 				return
 			}
+			sessionId = sessions[i]
+			frames = session.Frames
+			topology = session.Topology
+			// the mapping captured with the session, so it stays correct when
+			// the kernel names of the disks change between boots
+			diskMapping = session.DiskMapping
 			frameIndex = 0
+			count, err := loadAnomalies(sessions[i])
 			paginationView.SetText(fmt.Sprintf("1 of %d", len(frames)))
+			switch {
+			case err != nil:
+				logsView.SetText(fmt.Sprintf("Session %s. Unable to verify it. %s", mainText, err))
+			case count > 0:
+				logsView.SetText(fmt.Sprintf("Session %s. [red]%d anomalies[-]", mainText, count))
+			default:
+				logsView.SetText(fmt.Sprintf("Session %s", mainText))
+			}
 			printRightPanel(frames[0])
-			logsView.SetText(fmt.Sprintf("Session %s", mainText))
 			app.Draw()
 		}()
 
@@ -443,15 +522,24 @@ func refreshAvailableSessions(sessionsList *tview.List) {
 func printRightPanel(frame Frame) {
 	framesView.SetText(frame.timestamp())
 	statsView.SetText(frame.adaptedDiskstats(diskFilter))
-	powerView.SetText(frame.Power)
+	procIOView.SetText(frame.ProcIO)
+	if frame.PowerMode != "" {
+		powerView.SetText(frame.Power + "\nreported by drive:\n" + frame.PowerMode)
+	} else {
+		powerView.SetText(frame.Power)
+	}
 	hdIdleStdoutView.SetText(frame.Stdout)
 	hdIdleLogView.SetText(frame.adaptedLog())
+	if text := frameAnomalies(frame); text != "" {
+		logsView.SetText(text)
+	}
 }
 
 func clearRightPanel() {
 	paginationView.SetText("0 of 0")
 	framesView.Clear()
 	statsView.Clear()
+	procIOView.Clear()
 	powerView.Clear()
 	hdIdleStdoutView.Clear()
 	hdIdleLogView.Clear()
@@ -536,19 +624,19 @@ func requestSessionsFromDaemon() ([]string, error) {
 
 	var response Response
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("unable to parse response body. " + err.Error())
+		return nil, fmt.Errorf("unable to parse response body. %s", err.Error())
 	}
 	return response.Sessions, nil
 }
 
-func requestSessionFromDaemon(id string) ([]Frame, error) {
+func requestSessionFromDaemon(id string) (Session, error) {
 	client, err := openClient()
 	if err != nil {
 		panic(err)
 	}
 	resp, err := client.Get("http://unix/sessions/" + id)
 	if err != nil {
-		return nil, err
+		return Session{}, err
 	}
 	defer resp.Body.Close()
 
@@ -558,20 +646,16 @@ func requestSessionFromDaemon(id string) ([]Frame, error) {
 		}
 		var response Response
 		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("unable to parse response body. " + err.Error())
+			return Session{}, fmt.Errorf("unable to parse response body. %s", err.Error())
 		}
-		return nil, fmt.Errorf("server error: %s", response.Error)
+		return Session{}, fmt.Errorf("server error: %s", response.Error)
 	}
 
-	type Response struct {
-		Frames []Frame `json:"frames"`
-	}
-
-	var response Response
-	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("unable to parse response body. " + err.Error())
+	var session Session
+	if err = json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return Session{}, fmt.Errorf("unable to parse response body. %s", err.Error())
 	}
-	return response.Frames, nil
+	return session, nil
 }
 
 func sendDaemon(endpoint, message string) (string, error) {
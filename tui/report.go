@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// runReport implements "hdt report", saving the HTML report of a session.
+func runReport(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: hdt report [options] <session>\n")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "", "file to write the report to, <session>.html by default, - for stdout")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	id := flags.Arg(0)
+
+	report, err := requestReportFromDaemon(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(report)
+	} else {
+		if *output == "" {
+			*output = strings.ReplaceAll(id, ";", "_") + ".html"
+		}
+		err = os.WriteFile(*output, report, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *output != "-" {
+		fmt.Printf("Report written to %s\n", *output)
+	}
+	return 0
+}
+
+func requestReportFromDaemon(id string) ([]byte, error) {
+	client, err := openClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get("http://unix/sessions/" + id + "/report")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Error string `json:"error"`
+		}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("unable to parse response body. %s", err.Error())
+		}
+		return nil, fmt.Errorf("server error: %s", response.Error)
+	}
+	return body, nil
+}
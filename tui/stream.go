@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type StreamFrame struct {
+	Frame
+	Session string `json:"session"`
+	Replay  bool   `json:"replay"`
+}
+
+var followStream io.Closer
+
+// toggleFollow starts or stops following the frames the daemon records or
+// replays. While following, the right panel jumps to every new frame unless
+// an older one is being looked at.
+func toggleFollow() {
+	if followStream != nil {
+		followStream.Close()
+		followStream = nil
+		logsView.SetText("Stopped following.")
+		return
+	}
+
+	client, err := openClient()
+	if err != nil {
+		logsView.SetText("Error following. " + err.Error())
+		return
+	}
+	resp, err := client.Get("http://unix/stream")
+	if err != nil {
+		logsView.SetText("Error following. " + err.Error())
+		return
+	}
+	followStream = resp.Body
+	logsView.SetText("Following, waiting for frames...")
+
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			data, found := strings.CutPrefix(scanner.Text(), "data:")
+			if !found {
+				continue
+			}
+			var frame StreamFrame
+			if err := json.Unmarshal([]byte(data), &frame); err != nil {
+				continue
+			}
+			app.QueueUpdateDraw(func() {
+				showStreamFrame(frame)
+			})
+		}
+	}()
+}
+
+func showStreamFrame(frame StreamFrame) {
+	if frame.Session != sessionId {
+		sessionId = frame.Session
+		anomalies = nil
+		frames = nil
+		frameIndex = 0
+	}
+	atLastFrame := frameIndex >= len(frames)-1
+	frames = append(frames, frame.Frame)
+	if atLastFrame {
+		frameIndex = len(frames) - 1
+		printRightPanel(frames[frameIndex])
+	}
+	paginationView.SetText(fmt.Sprintf("%d of %d", frameIndex+1, len(frames)))
+
+	name, _, _ := strings.Cut(frame.Session, ";")
+	if frame.Replay {
+		logsView.SetText(fmt.Sprintf("Following replay of session %s", name))
+	} else {
+		logsView.SetText(fmt.Sprintf("Following session %s", name))
+	}
+}
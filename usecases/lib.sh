@@ -0,0 +1,146 @@
+#!/bin/sh
+# Helpers shared by the scenario scripts.
+#
+# When HDIDLE_BIN is set, the daemon launches that hd-idle binary with
+# HDIDLE_ARGS for the duration of the recording. When SESSION_FILE is set, the
+# id of the recorded session is written to it. SPD_SOCKET points is_up to
+# another spd, e.g. the simulator's. With HDSIM_SOCKET, the writes of
+# write_on go to the simulated $DISK instead of $MOUNT.
+
+HDTD_SOCKET=${HDTD_SOCKET:-/tmp/hdtd.sock}
+SPD_SOCKET=${SPD_SOCKET:-/tmp/spd.sock}
+DISK=${DISK:-sda}
+MOUNT=${MOUNT:-/mnt/one}
+
+# start_recording <name>
+start_recording() {
+  if [ -n "$HDIDLE_BIN" ]; then
+    request=$(jq -nc --arg name "$1" --arg bin "$HDIDLE_BIN" --arg args "$HDIDLE_ARGS" \
+      '{name: $name, action: "start", hdidle: {binary: $bin, args: ($args | split(" ") | map(select(. != "")))}}')
+  else
+    request=$(jq -nc --arg name "$1" '{name: $name, action: "start"}')
+  fi
+  SESSION=$(curl -sX POST -H 'Content-Type: application/json' \
+    --data "$request" \
+    --unix-socket "$HDTD_SOCKET" \
+    "http://unix/record" | jq -r .session)
+  if [ -n "$SESSION_FILE" ]; then
+    echo "$SESSION" > "$SESSION_FILE"
+  fi
+}
+
+# stop_recording <name>
+stop_recording() {
+  curl -sX POST -H 'Content-Type: application/json' \
+    --data "$(jq -nc --arg name "$1" '{name: $name, action: "stop"}')" \
+    --unix-socket "$HDTD_SOCKET" \
+    "http://unix/record" > /dev/null
+}
+
+# wait_for <seconds>
+# Sleeps, or moves the clock forward when the daemon runs on virtual time.
+wait_for() {
+  if [ "$(curl -sX GET --unix-socket "$HDTD_SOCKET" "http://unix/clock" | jq .virtual)" = "true" ]; then
+    curl -sX POST -H 'Content-Type: application/json' \
+      --data "$(jq -nc --argjson advance "$1" '{advance: $advance}')" \
+      --unix-socket "$HDTD_SOCKET" \
+      "http://unix/clock" > /dev/null
+  else
+    sleep "$1"
+  fi
+}
+
+# is_up <disk>
+is_up() {
+  [ "$(curl -sX GET --unix-socket "$SPD_SOCKET" "http://unix/devices/$1" | jq .up)" = "true" ]
+}
+
+# report <name> <ok|fail> [message]
+report() {
+  curl -sX POST -H 'Content-Type: application/json' \
+    --data "$(jq -nc --arg verdict "$2" --arg message "$3" '{verdict: $verdict, message: $message}')" \
+    --unix-socket "$HDTD_SOCKET" \
+    "http://unix/sessions/$(jq -rn --arg s "$SESSION" '$s | @uri')/verdict" > /dev/null
+  printf '\e[2K\r'
+  if [ "$2" = "ok" ]; then
+    printf '\e[1A\r* %s \033[0;32mOK\033[0m\n' "$1"
+  else
+    printf '\e[1A\r* %s \033[0;31mFail\033[0m\n' "$1"
+  fi
+}
+
+# mark <label>
+# Adds a marker to the session being recorded, at the time of the daemon's
+# clock, which may be virtual.
+mark() {
+  now=$(curl -sX GET --unix-socket "$HDTD_SOCKET" "http://unix/clock" | jq -r .now)
+  curl -sX POST -H 'Content-Type: application/json' \
+    --data "$(jq -nc --arg label "$1" --arg now "$now" \
+      '{label: $label, operation: "scenario", start: $now, end: $now}')" \
+    --unix-socket "$HDTD_SOCKET" \
+    "http://unix/markers" > /dev/null
+}
+
+# write_on <label>
+# Writes a small file on $MOUNT, or on the simulated $DISK.
+write_on() {
+  if [ -n "$HDSIM_SOCKET" ]; then
+    curl -sX POST -H 'Content-Type: application/json' \
+      --data '{"writes": 1}' \
+      --unix-socket "$HDSIM_SOCKET" \
+      "http://unix/disks/$DISK/io" > /dev/null
+  else
+    hdwl write -path "$MOUNT/$(date +"%Y%m%d-%H%M").txt" -size 4K -fsync -label "$1" > /dev/null
+  fi
+}
+
+# running <process name>
+running() {
+  pidof "$1" > /dev/null
+}
+
+# run_wake_scenario <id> <name> <wakes|sleeps> <wake function>
+# Lets $DISK spin down, runs the wake source and checks the disk woke up or
+# stayed asleep as expected. Either way hd-idle has to spin it down again
+# within 12 minutes.
+run_wake_scenario() {
+  printf '* %s\n' "$2"
+  printf '  Start recording\r'
+  start_recording "$1"
+
+  printf '\e[2K\r  Sleeping 12m until %s spins down\r' "$DISK"
+  wait_for 720
+  if is_up "$DISK"; then
+    report "$2" fail "$DISK did not spin down before the wake source"
+    stop_recording "$1"
+    return
+  fi
+
+  printf '\e[2K\r  Running the wake source\r'
+  mark "$2"
+  $4 > /dev/null 2>&1 || true
+  wait_for 11
+
+  if is_up "$DISK" && [ "$3" = "sleeps" ]; then
+    report "$2" fail "$DISK woke up"
+    stop_recording "$1"
+    return
+  fi
+  if ! is_up "$DISK" && [ "$3" = "wakes" ]; then
+    report "$2" fail "$DISK did not wake up, the wake source had no effect"
+    stop_recording "$1"
+    return
+  fi
+
+  printf '\e[2K\r  Sleeping 12m\r'
+  wait_for 720
+  printf '\e[2K\r  Checking /dev/%s power\r' "$DISK"
+  if is_up "$DISK"; then
+    report "$2" fail "$DISK still up 12 minutes after the wake source"
+  else
+    report "$2" ok
+  fi
+
+  wait_for 11
+  stop_recording "$1"
+}
@@ -0,0 +1,71 @@
+#!/bin/sh
+# Runs one scenario against several hd-idle binaries and option sets, one
+# recorded session each, and prints a comparison table.
+#
+# e.g.
+#   ./matrix.sh -b /usr/sbin/hd-idle -b ./hd-idle-fix \
+#     -o "-i 600" -o "-i 0 -a sda -i 300" scripts/01_no_activity.sh
+
+usage() {
+  echo "Usage: $0 -b <hd-idle binary> [-b ...] [-o <hd-idle options>] [-o ...] <scenario script>"
+  exit 1
+}
+
+binaries=""
+options=""
+while getopts "b:o:" opt; do
+  case $opt in
+  b) binaries="$binaries$OPTARG
+" ;;
+  o) options="$options$OPTARG
+" ;;
+  *) usage ;;
+  esac
+done
+shift $((OPTIND - 1))
+
+scenario="$1"
+if [ -z "$binaries" ] || [ -z "$scenario" ]; then
+  usage
+fi
+if [ -z "$options" ]; then
+  options="
+"
+fi
+
+HDTD_SOCKET=${HDTD_SOCKET:-/tmp/hdtd.sock}
+SESSION_FILE=$(mktemp)
+results=$(mktemp)
+trap 'rm -f "$SESSION_FILE" "$results"' EXIT
+export SESSION_FILE
+
+echo "$binaries" | while IFS= read -r binary; do
+  [ -z "$binary" ] && continue
+  echo "$options" | while IFS= read -r args; do
+    printf '\n%s %s\n' "$binary" "$args"
+    : > "$SESSION_FILE"
+    # the scenario must not read the binaries and options left in the loop
+    HDIDLE_BIN="$binary" HDIDLE_ARGS="$args" bash "$scenario" < /dev/null
+    session=$(cat "$SESSION_FILE")
+    label="${args:--}"
+    if [ -z "$session" ]; then
+      printf '%s\t%s\t-\t-\t-\n' "$binary" "$label" >> "$results"
+      continue
+    fi
+    curl -sX GET --unix-socket "$HDTD_SOCKET" \
+      "http://unix/sessions/$(jq -rn --arg s "$session" '$s | @uri')/summary" |
+      jq -r --arg binary "$binary" --arg args "$label" '
+        [.disks[]?.spin_down_latencies[]] as $latencies
+        | [$binary, $args,
+           (.verdict.verdict // "-"),
+           (if ($latencies | length) > 0 then ($latencies | add / length | floor | tostring) + "s" else "-" end),
+           ([.disks[]?.spurious_spin_ups] | add // 0 | tostring)]
+        | @tsv' >> "$results"
+  done
+done
+
+echo
+{
+  printf 'BINARY\tOPTIONS\tVERDICT\tSPIN-DOWN LATENCY\tSPURIOUS SPIN-UPS\n'
+  cat "$results"
+} | column -t -s "$(printf '\t')"
@@ -1,22 +1,42 @@
 #!/bin/sh
+# Usage: run.sh [-l] [scenario script ...]
+# Runs the given scenarios, all of them by default. -l lists them with their
+# expected outcome.
 
-pid=$(pidof hd-idle)
-
-if [ "$?" -eq 1 ]; then
-  echo "Error! hd-idle is not running"
-  echo "Run the command to start:"
-  echo "  # touch /var/log/hd-idle.log && nohup /usr/sbin/hd-idle -l /var/log/hd-idle.log > /tmp/hd-idle.out 2>&1 &"
-  exit 1
+if [ "$1" = "-l" ]; then
+  for f in scripts/*.sh; do
+    printf '%s\n  %s\n' "$(basename "$f")" "$(sed -n 's/^# Expected: //p' "$f")"
+  done
+  exit 0
 fi
 
-echo
-echo "hd-idle pid: $pid"
-echo
+if [ -n "$HDIDLE_BIN" ]; then
+  # the daemon launches hd-idle for every recording
+  echo
+  echo "hd-idle: $HDIDLE_BIN $HDIDLE_ARGS"
+  echo
+else
+  pid=$(pidof hd-idle)
+
+  if [ "$?" -eq 1 ]; then
+    echo "Error! hd-idle is not running"
+    echo "Run the command to start:"
+    echo "  # touch /var/log/hd-idle.log && nohup /usr/sbin/hd-idle -l /var/log/hd-idle.log > /tmp/hd-idle.out 2>&1 &"
+    exit 1
+  fi
+
+  echo
+  echo "hd-idle pid: $pid"
+  echo
+fi
 echo " ┌────────────────────────┐"
 echo " │ hd-idle test scenarios │"
 echo " └────────────────────────┘"
 echo
 
-for f in scripts/*.sh; do
+if [ "$#" -eq 0 ]; then
+  set -- scripts/*.sh
+fi
+for f in "$@"; do
   bash "$f"
 done
\ No newline at end of file
@@ -1,4 +1,7 @@
 #!/bin/sh -e
+# Expected: the disk spins down 10 minutes after the last write.
+
+. "$(dirname "$0")/../lib.sh"
 
 ID="01"
 NAME="Single disk partition spins down after 10 minutes"
@@ -6,39 +9,31 @@ printf '* %s\n' "$NAME"
 
 # start recording
 printf '  Start recording\r'
-curl -X POST -H 'Content-Type: application/json' \
-  --data '{"name":"'"${ID}"'","action":"start"}' \
-  --unix-socket /tmp/hdtd.sock \
-  "http://unix/record"
-sleep 1
+start_recording "$ID"
+wait_for 1
 
 # sleeping 11s
 printf '\e[2K\r  Sleeping 11s\r'
-sleep 11
+wait_for 11
 
 # write on
-printf '\e[2K\r  Write on /mnt/one\r'
-date +"%Y-%m-%d %H:%M" > "/mnt/one/$(date +"%Y%m%d-%H%M").txt"
+printf '\e[2K\r  Write on %s\r' "$MOUNT"
+write_on "write on $MOUNT"
 
 # sleep 12m
 printf '\e[2K\r  Sleeping 12m\r'
-sleep 720
+wait_for 720
 
 # checking
-printf '\e[2K\r  Checking /dev/sda power\r'
+printf '\e[2K\r  Checking /dev/%s power\r' "$DISK"
 
-up=$(curl -sX GET --unix-socket /tmp/spd.sock "http://unix/devices/sda" |jq .up)
-printf '\e[2K\r'
-if [ $up = "true" ]; then
-  printf '\e[1A\r* %s \033[0;31mFail\033[0m\n' "$NAME"
+if is_up "$DISK"; then
+  report "$NAME" fail "$DISK still up 12 minutes after the last write"
 else
-  printf '\e[1A\r* %s \033[0;32mOK\033[0m\n' "$NAME"
+  report "$NAME" ok
 fi
 
-sleep 11
+wait_for 11
 
 # stop recording
-curl -X POST -H 'Content-Type: application/json' \
-  --data '{"name":"'"${ID}"'","action":"stop"}' \
-  --unix-socket /tmp/hdtd.sock \
-  "http://unix/record"
+stop_recording "$ID"
@@ -1,4 +1,7 @@
 #!/bin/sh -e
+# Expected: hdparm -C wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
 
 ID="02"
 NAME="hdparm power status check spins up disk, but then spins down after 10 minutes"
@@ -6,38 +9,30 @@ printf '* %s\n' "$NAME"
 
 # start recording
 printf '  Start recording\r'
-curl -X POST -H 'Content-Type: application/json' \
-  --data '{"name":"'"${ID}"'","action":"start"}' \
-  --unix-socket /tmp/hdtd.sock \
-  "http://unix/record"
+start_recording "$ID"
 
 printf '\e[2K\r  Sleeping 11s\r'
-sleep 11
+wait_for 11
 
 printf '\e[2K\r  Sleeping 12m\r'
-sleep 720
+wait_for 720
 
 printf '\e[2K\r  invoking hdparm\r'
-sudo hdparm -C /dev/sda > /dev/null 2>&1
+sudo hdparm -C "/dev/$DISK" > /dev/null 2>&1
 
 printf '\e[2K\r  Sleeping 12m after invoking hdparm\r'
-sleep 720
+wait_for 720
 
-# assert sda is spun down
-printf '\e[2K\r  Checking /dev/sda power\r'
+# assert $DISK is spun down
+printf '\e[2K\r  Checking /dev/%s power\r' "$DISK"
 
-up=$(curl -sX GET --unix-socket /tmp/spd.sock "http://unix/devices/sda" |jq .up)
-printf '\e[2K\r'
-if [ $up = "true" ]; then
-  printf '\e[1A\r* %s \033[0;31mFail\033[0m\n' "$NAME"
+if is_up "$DISK"; then
+  report "$NAME" fail "$DISK still up 12 minutes after hdparm -C"
 else
-  printf '\e[1A\r* %s \033[0;32mOK\033[0m\n' "$NAME"
+  report "$NAME" ok
 fi
 
-sleep 11
+wait_for 11
 
 # stop recording
-curl -X POST -H 'Content-Type: application/json' \
-  --data '{"name":"'"${ID}"'","action":"stop"}' \
-  --unix-socket /tmp/hdtd.sock \
-  "http://unix/record"
+stop_recording "$ID"
@@ -0,0 +1,17 @@
+#!/bin/sh -e
+# Expected: smartd polling wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
+
+# smartd reads the SMART attributes on every poll. With the default
+# "-n never" it doesn't check the power mode first. Without smartd, its poll
+# is done by hand.
+wake() {
+  if running smartd; then
+    sudo systemctl reload smartd || sudo systemctl reload smartmontools
+  else
+    sudo smartctl -A "/dev/$DISK"
+  fi
+}
+
+run_wake_scenario "03" "smartd polling wakes the disk, but then spins down after 10 minutes" wakes wake
@@ -0,0 +1,10 @@
+#!/bin/sh -e
+# Expected: smartctl -n standby leaves the disk asleep.
+
+. "$(dirname "$0")/../lib.sh"
+
+wake() {
+  sudo smartctl -n standby -A "/dev/$DISK"
+}
+
+run_wake_scenario "04" "smartctl -n standby doesn't wake the disk" sleeps wake
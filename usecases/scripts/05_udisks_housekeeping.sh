@@ -0,0 +1,18 @@
+#!/bin/sh -e
+# Expected: udisks2 housekeeping leaves the disk asleep.
+
+. "$(dirname "$0")/../lib.sh"
+
+# udisks2 refreshes the SMART data of ATA disks every 10 minutes, asking not
+# to wake them up. Without udisksd, the same refresh is done with smartctl.
+wake() {
+  if running udisksd; then
+    object=$(udisksctl info -b "/dev/$DISK" | awk '/Drive:/ {gsub("\x27", "", $2); print $2}')
+    gdbus call --system --dest org.freedesktop.UDisks2 --object-path "$object" \
+      --method org.freedesktop.UDisks2.Drive.Ata.SmartUpdate "{'nowakeup': <true>}"
+  else
+    sudo smartctl -n standby -A "/dev/$DISK"
+  fi
+}
+
+run_wake_scenario "05" "udisks2 housekeeping doesn't wake the disk" sleeps wake
@@ -0,0 +1,11 @@
+#!/bin/sh -e
+# Expected: remounting wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
+
+wake() {
+  sudo umount "$MOUNT"
+  sudo mount "$MOUNT"
+}
+
+run_wake_scenario "06" "remounting $MOUNT wakes the disk, but then spins down after 10 minutes" wakes wake
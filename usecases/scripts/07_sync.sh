@@ -0,0 +1,10 @@
+#!/bin/sh -e
+# Expected: sync without dirty data leaves the disk asleep.
+
+. "$(dirname "$0")/../lib.sh"
+
+wake() {
+  sync
+}
+
+run_wake_scenario "07" "sync without pending writes doesn't wake the disk" sleeps wake
@@ -0,0 +1,16 @@
+#!/bin/sh -e
+# Expected: fstrim wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
+
+# The discards issued by fstrim show in /proc/diskstats apart from the reads
+# and writes. Without the timer, fstrim is run by hand.
+wake() {
+  if systemctl list-timers fstrim.timer 2> /dev/null | grep -q fstrim; then
+    sudo systemctl start fstrim.service
+  else
+    sudo fstrim -v "$MOUNT"
+  fi
+}
+
+run_wake_scenario "08" "fstrim wakes the disk, but then spins down after 10 minutes" wakes wake
@@ -0,0 +1,20 @@
+#!/bin/sh -e
+# Expected: a Samba directory listing wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
+
+# The listing is requested from a local client, once the page cache is
+# dropped so it can't be answered from memory. Without Samba, the directory
+# is listed directly.
+SHARE=${SHARE:-one}
+
+wake() {
+  sudo hdwl drop-caches -socket ""
+  if running smbd; then
+    smbclient "//localhost/$SHARE" -N -c ls
+  else
+    hdwl stat -path "$MOUNT" -label "samba listing stand-in"
+  fi
+}
+
+run_wake_scenario "09" "Samba listing of $MOUNT wakes the disk, but then spins down after 10 minutes" wakes wake
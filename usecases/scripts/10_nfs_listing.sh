@@ -0,0 +1,22 @@
+#!/bin/sh -e
+# Expected: an NFS directory listing wakes the disk, hd-idle spins it down again.
+
+. "$(dirname "$0")/../lib.sh"
+
+# $MOUNT has to be exported for the local client. Without nfsd, the directory
+# is listed directly.
+NFS_MOUNT=/tmp/hdt-nfs
+
+wake() {
+  sudo hdwl drop-caches -socket ""
+  if running nfsd; then
+    mkdir -p "$NFS_MOUNT"
+    sudo mount -t nfs "localhost:$MOUNT" "$NFS_MOUNT"
+    ls -l "$NFS_MOUNT"
+    sudo umount "$NFS_MOUNT"
+  else
+    hdwl stat -path "$MOUNT" -label "nfs listing stand-in"
+  fi
+}
+
+run_wake_scenario "10" "NFS listing of $MOUNT wakes the disk, but then spins down after 10 minutes" wakes wake
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const socketFile = "/tmp/hdtd.sock"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	path := flags.String("path", "", "file or directory to work on")
+	size := flags.String("size", "4K", "bytes to write or map, e.g. 512, 4K, 10M")
+	direct := flags.Bool("direct", false, "bypass the page cache with O_DIRECT")
+	fsync := flags.Bool("fsync", false, "fsync every written file")
+	dropCaches := flags.Bool("drop-caches", false, "drop the page cache before reading (needs root)")
+	count := flags.Int("count", 10, "files written by a burst")
+	rate := flags.Float64("rate", 1, "files per second written by a burst, 0 for as fast as possible")
+	write := flags.Bool("write", false, "write to the mapped pages instead of reading them")
+	label := flags.String("label", "", "label of the marker, the operation by default")
+	socket := flags.String("socket", socketFile, "daemon socket the marker is sent to, empty for none")
+	_ = flags.Parse(os.Args[2:])
+
+	if *path == "" && os.Args[1] != "drop-caches" {
+		fmt.Fprintln(os.Stderr, "missing -path")
+		os.Exit(1)
+	}
+	bytes, err := parseSize(*size)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var res result
+	switch os.Args[1] {
+	case "write":
+		res, err = writeFile(*path, bytes, *direct, *fsync)
+	case "read":
+		res, err = readFile(*path, *direct, *dropCaches)
+	case "stat":
+		res, err = statPath(*path)
+	case "drop-caches":
+		res, err = dropPageCache()
+	case "burst":
+		res, err = burst(*path, *count, bytes, *rate, *direct, *fsync)
+	case "mmap":
+		sizeSet := false
+		flags.Visit(func(f *flag.Flag) {
+			sizeSet = sizeSet || f.Name == "size"
+		})
+		if !*write && !sizeSet {
+			// map the whole file
+			bytes = 0
+		}
+		res, err = mmapFile(*path, bytes, *write)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s failed after %d bytes. %s\n", res.Operation, res.Path, res.Bytes, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s %s: %d bytes from %s to %s (%s)\n", res.Operation, res.Path, res.Bytes,
+		res.Start.Format(time.RFC3339Nano), res.End.Format(time.RFC3339Nano), res.Detail)
+	if *socket != "" {
+		if *label == "" {
+			*label = res.Operation
+		}
+		if err = sendMarker(*socket, *label, res); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to send the marker. %s\n", err)
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: hdwl <operation> -path <path> [options]
+
+Operations:
+  write   write -size bytes to a file [-direct] [-fsync]
+  read    read a whole file [-direct] [-drop-caches]
+  stat    stat a file, or every entry of a directory
+  burst   write -count files of -size bytes in a directory at -rate per second [-direct] [-fsync]
+  mmap    map -size bytes of a file and touch every page [-write]
+  drop-caches
+          sync and drop the page cache, without -path (needs root)
+
+Run hdwl <operation> -h for every option.`)
+	os.Exit(1)
+}
+
+// sendMarker adds the operation to the session being recorded.
+func sendMarker(socket, label string, res result) error {
+	type Request struct {
+		Label     string `json:"label"`
+		Operation string `json:"operation"`
+		Path      string `json:"path"`
+		Bytes     int64  `json:"bytes"`
+		Start     string `json:"start"`
+		End       string `json:"end"`
+		Detail    string `json:"detail"`
+	}
+	body, err := json.Marshal(Request{
+		Label:     label,
+		Operation: res.Operation,
+		Path:      res.Path,
+		Bytes:     res.Bytes,
+		Start:     res.Start.Format(time.RFC3339Nano),
+		End:       res.End.Format(time.RFC3339Nano),
+		Detail:    res.Detail,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	resp, err := client.Post("http://unix/markers", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		type Response struct {
+			Error string `json:"error"`
+		}
+		var response Response
+		_ = json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("daemon error: %s", response.Error)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	blockSize       = 4096
+	dropCachesFile  = "/proc/sys/vm/drop_caches"
+	dropCachesValue = "3"
+)
+
+// result is what an operation issued, sent to the daemon as a marker.
+type result struct {
+	Operation string
+	Path      string
+	Bytes     int64
+	Start     time.Time
+	End       time.Time
+	Detail    string
+}
+
+// writeFile writes size bytes to the file. Buffered writes may stay in the
+// page cache until the kernel flushes them, direct writes (O_DIRECT) and
+// fsync reach the disk before returning.
+func writeFile(path string, size int64, direct, fsync bool) (result, error) {
+	res := result{Operation: "write", Path: path, Start: time.Now(), Detail: ioMode(direct, fsync)}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if direct {
+		flags |= syscall.O_DIRECT
+		size = alignUp(size)
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return res, err
+	}
+	defer file.Close()
+
+	buffer := alignedBuffer(min(size, 1<<20))
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+	for res.Bytes < size {
+		chunk := buffer[:min(int64(len(buffer)), size-res.Bytes)]
+		n, err := file.Write(chunk)
+		res.Bytes += int64(n)
+		if err != nil {
+			return res, err
+		}
+	}
+	if fsync {
+		if err = file.Sync(); err != nil {
+			return res, err
+		}
+	}
+	res.End = time.Now()
+	return res, nil
+}
+
+// dropPageCache writes the dirty pages back and empties the page cache, the
+// dentries and the inodes, so the next access has to go to the disk.
+func dropPageCache() (result, error) {
+	res := result{Operation: "drop-caches", Path: dropCachesFile, Start: time.Now(), Detail: "synced"}
+	syscall.Sync()
+	if err := os.WriteFile(dropCachesFile, []byte(dropCachesValue), 0200); err != nil {
+		return res, fmt.Errorf("unable to drop caches. %w", err)
+	}
+	res.End = time.Now()
+	return res, nil
+}
+
+// readFile reads the whole file. With dropCaches the page cache is emptied
+// first, so the data has to come from the disk.
+func readFile(path string, direct, dropCaches bool) (result, error) {
+	res := result{Operation: "read", Path: path, Start: time.Now(), Detail: ioMode(direct, false)}
+	if dropCaches {
+		if _, err := dropPageCache(); err != nil {
+			return res, err
+		}
+		res.Detail += ", caches dropped"
+	}
+
+	flags := os.O_RDONLY
+	if direct {
+		flags |= syscall.O_DIRECT
+	}
+	file, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return res, err
+	}
+	defer file.Close()
+
+	buffer := alignedBuffer(1 << 20)
+	for {
+		n, err := file.Read(buffer)
+		res.Bytes += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	res.End = time.Now()
+	return res, nil
+}
+
+// statPath only touches metadata: stat on a file, stat of every entry on a
+// directory, like ls -l.
+func statPath(path string) (result, error) {
+	res := result{Operation: "stat", Path: path, Start: time.Now()}
+	info, err := os.Stat(path)
+	if err != nil {
+		return res, err
+	}
+	count := 1
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return res, err
+		}
+		for _, e := range entries {
+			if _, err = os.Lstat(filepath.Join(path, e.Name())); err == nil {
+				count++
+			}
+		}
+	}
+	res.Detail = fmt.Sprintf("%d entries", count)
+	res.End = time.Now()
+	return res, nil
+}
+
+// burst writes count files of size bytes in dir, at rate files per second.
+func burst(dir string, count int, size int64, rate float64, direct, fsync bool) (result, error) {
+	res := result{Operation: "burst", Path: dir, Start: time.Now(),
+		Detail: fmt.Sprintf("%d files at %g/s, %s", count, rate, ioMode(direct, fsync))}
+
+	interval := time.Duration(0)
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(time.Until(res.Start.Add(time.Duration(i) * interval)))
+		}
+		path := filepath.Join(dir, fmt.Sprintf("burst-%d-%d", res.Start.Unix(), i))
+		written, err := writeFile(path, size, direct, fsync)
+		res.Bytes += written.Bytes
+		if err != nil {
+			return res, err
+		}
+	}
+	res.End = time.Now()
+	return res, nil
+}
+
+// mmapFile maps size bytes of the file and touches every page, writing to
+// them and flushing them with msync when write is set.
+func mmapFile(path string, size int64, write bool) (result, error) {
+	res := result{Operation: "mmap", Path: path, Start: time.Now(), Detail: "read"}
+
+	flags, prot := os.O_RDONLY, syscall.PROT_READ
+	if write {
+		flags, prot = os.O_RDWR|os.O_CREATE, syscall.PROT_READ|syscall.PROT_WRITE
+		res.Detail = "write"
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return res, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return res, err
+	}
+	if write && info.Size() < size {
+		if err = file.Truncate(size); err != nil {
+			return res, err
+		}
+	} else if size == 0 || size > info.Size() {
+		size = info.Size()
+	}
+	if size == 0 {
+		return res, fmt.Errorf("nothing to map in %s", path)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return res, err
+	}
+	defer syscall.Munmap(data)
+
+	var sum byte
+	for i := 0; i < len(data); i += blockSize {
+		if write {
+			data[i] = byte(i)
+		} else {
+			sum += data[i]
+		}
+	}
+	if write {
+		_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+			uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+		if errno != 0 {
+			return res, errno
+		}
+	}
+	res.Bytes = size
+	res.End = time.Now()
+	return res, nil
+}
+
+func ioMode(direct, fsync bool) string {
+	mode := "buffered"
+	if direct {
+		mode = "direct"
+	}
+	if fsync {
+		mode += ", fsync"
+	}
+	return mode
+}
+
+func alignUp(size int64) int64 {
+	return (size + blockSize - 1) / blockSize * blockSize
+}
+
+// alignedBuffer returns a buffer aligned to the block size, as O_DIRECT
+// requires.
+func alignedBuffer(size int64) []byte {
+	size = alignUp(max(size, blockSize))
+	buffer := make([]byte, size+blockSize)
+	offset := int(blockSize - uintptr(unsafe.Pointer(&buffer[0]))%blockSize)
+	if offset == blockSize {
+		offset = 0
+	}
+	return buffer[offset : offset+int(size)]
+}
+
+// parseSize reads sizes like 512, 4K, 10M or 1G.
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	number, err := strconv.ParseInt(strings.TrimRight(value, "KMG"), 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	return number * multiplier, nil
+}